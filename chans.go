@@ -0,0 +1,303 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// selectCase tries to replace a select statement by one of its cases, as
+// if it had been the one chosen. The communication of the case is kept
+// as a statement of its own.
+func (r *reducer) selectCase(sel *ast.SelectStmt) bool {
+	for _, stmt := range sel.Body.List {
+		cc := stmt.(*ast.CommClause)
+		var pre []ast.Stmt
+		if cc.Comm != nil {
+			pre = append(pre, cc.Comm)
+		}
+		var deleted []ast.Node
+		for _, stmt2 := range sel.Body.List {
+			if stmt2 != stmt {
+				deleted = append(deleted, stmt2)
+			}
+		}
+		if r.unwrapStmt(sel, sel.Body.Lbrace, pre, &cc.Body, deleted) {
+			if cc.Comm == nil {
+				r.logChange(sel, "select -> default case")
+			} else {
+				r.logChange(sel, "select -> case")
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// isRecv reports whether an expression receives from a channel.
+func isRecv(expr ast.Expr) bool {
+	ue, _ := expr.(*ast.UnaryExpr)
+	return ue != nil && ue.Op == token.ARROW
+}
+
+// removeRecv tries to replace a declaration of a variable by receiving
+// from a channel, as in v := <-ch, by a declaration of the variable
+// without a value.
+func (r *reducer) removeRecv(as *ast.AssignStmt) bool {
+	if as.Tok != token.DEFINE || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		return false
+	}
+	if !isRecv(as.Rhs[0]) || r.parentStmts(as) == nil {
+		return false
+	}
+	id, _ := as.Lhs[0].(*ast.Ident)
+	if id == nil || r.info.Defs[id] == nil {
+		return false
+	}
+	typ := r.typeExpr(r.info.Defs[id].Type(), as.Pos())
+	if typ == nil {
+		return false
+	}
+	ds := &ast.DeclStmt{Decl: &ast.GenDecl{
+		TokPos: as.Pos(),
+		Tok:    token.VAR,
+		Specs: []ast.Spec{&ast.ValueSpec{
+			Names: []*ast.Ident{id},
+			Type:  typ,
+		}},
+	}}
+	r.afterDelete(as.Rhs[0])
+	if !r.replacedStmts(as, []ast.Stmt{ds}) {
+		return false
+	}
+	r.setParents(ds, r.parents[as])
+	return true
+}
+
+// removeSend tries to replace a send statement by the call whose result
+// it sends, as in ch <- f().
+func (r *reducer) removeSend(ss *ast.SendStmt) bool {
+	ce, _ := ss.Value.(*ast.CallExpr)
+	if ce == nil {
+		return false
+	}
+	r.afterDelete(ss.Chan)
+	return r.changedStmt(ss, &ast.ExprStmt{X: ce})
+}
+
+// makeChan returns the channel type made by a call to make, or nil if
+// the call isn't one.
+func (r *reducer) makeChan(ce *ast.CallExpr) *types.Chan {
+	id, _ := ce.Fun.(*ast.Ident)
+	if id == nil || len(ce.Args) == 0 {
+		return nil
+	}
+	if _, ok := r.info.Uses[id].(*types.Builtin); !ok || id.Name != "make" {
+		return nil
+	}
+	ch, _ := r.info.TypeOf(ce.Args[0]).Underlying().(*types.Chan)
+	return ch
+}
+
+// reduceChanBuffer tries to make a buffered channel unbuffered, or the
+// other way around. As adding a buffer makes the code larger, that is
+// only done along with removing a receive from the channel, which the
+// buffer might have made unnecessary.
+func (r *reducer) reduceChanBuffer(ce *ast.CallExpr) bool {
+	if r.makeChan(ce) == nil {
+		return false
+	}
+	args := ce.Args
+	if len(args) > 1 {
+		ce.Args = args[:1]
+		r.afterDelete(args[1])
+		if r.okChange() {
+			r.mergeLines(args[0].End(), args[1].End())
+			r.logChange(ce, "make(chan T, n) -> make(chan T)")
+			return true
+		}
+		ce.Args = args
+		return false
+	}
+	obj := r.madeVar(ce)
+	if obj == nil {
+		return false
+	}
+	one := &ast.BasicLit{ValuePos: args[0].End(), Kind: token.INT, Value: "1"}
+	for _, use := range r.useIdents[obj] {
+		if r.nodeFile(use) != r.file {
+			continue
+		}
+		ue, _ := r.parents[use].(*ast.UnaryExpr)
+		if ue == nil || ue.Op != token.ARROW {
+			continue
+		}
+		es, _ := r.parents[ue].(*ast.ExprStmt)
+		if es == nil || r.parentStmts(es) == nil {
+			continue
+		}
+		ce.Args = []ast.Expr{args[0], one}
+		undo := r.replaceStmts(es, nil)
+		r.afterDelete(es)
+		if r.okChange() {
+			r.parents[one] = ce
+			r.mergeLines(es.Pos(), es.End()+1)
+			r.logChange(ce, "make(chan T) -> make(chan T, 1), removing a receive")
+			return true
+		}
+		undo()
+		ce.Args = args
+	}
+	return false
+}
+
+// madeVar returns the variable that the result of a call is assigned to
+// or declared with, or nil if there isn't one.
+func (r *reducer) madeVar(ce *ast.CallExpr) types.Object {
+	var lhs []ast.Expr
+	var rhs []ast.Expr
+	switch x := r.parents[ce].(type) {
+	case *ast.AssignStmt:
+		lhs, rhs = x.Lhs, x.Rhs
+	case *ast.ValueSpec:
+		for _, name := range x.Names {
+			lhs = append(lhs, name)
+		}
+		rhs = x.Values
+	}
+	if len(lhs) != len(rhs) {
+		return nil
+	}
+	for i, expr := range rhs {
+		if expr != ce {
+			continue
+		}
+		id, _ := lhs[i].(*ast.Ident)
+		if id == nil {
+			return nil
+		}
+		if obj := r.info.Defs[id]; obj != nil {
+			return obj
+		}
+		return r.info.Uses[id]
+	}
+	return nil
+}
+
+// syncMethod returns the object that a call to a method of a sync.Mutex,
+// sync.RWMutex or sync.WaitGroup is made on, or nil if ce isn't such a
+// call. The object is a variable or a struct field.
+func (r *reducer) syncMethod(ce *ast.CallExpr) types.Object {
+	sel, _ := ce.Fun.(*ast.SelectorExpr)
+	if sel == nil {
+		return nil
+	}
+	fn, _ := r.info.Uses[sel.Sel].(*types.Func)
+	if fn == nil || fn.Pkg() == nil || fn.Pkg().Path() != "sync" {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, _ := t.(*types.Named)
+	if named == nil {
+		return nil
+	}
+	switch named.Obj().Name() {
+	case "Mutex", "RWMutex", "WaitGroup":
+	default:
+		return nil
+	}
+	switch x := ast.Unparen(sel.X).(type) {
+	case *ast.Ident:
+		return r.info.Uses[x]
+	case *ast.SelectorExpr:
+		return r.info.Uses[x.Sel]
+	}
+	return nil
+}
+
+// syncCallStmt returns the statement which only calls a sync method on
+// an object, given one of its uses, or nil if the use isn't one.
+func (r *reducer) syncCallStmt(obj types.Object, use *ast.Ident) ast.Stmt {
+	var expr ast.Node = use
+	if sel, _ := r.parents[use].(*ast.SelectorExpr); sel != nil && sel.Sel == use {
+		expr = sel // x.mu
+	}
+	for {
+		pe, _ := r.parents[expr].(*ast.ParenExpr)
+		if pe == nil {
+			break
+		}
+		expr = pe
+	}
+	sel, _ := r.parents[expr].(*ast.SelectorExpr)
+	if sel == nil {
+		return nil
+	}
+	ce, _ := r.parents[sel].(*ast.CallExpr)
+	if ce == nil || ce.Fun != sel || r.syncMethod(ce) != obj {
+		return nil
+	}
+	switch x := r.parents[ce].(type) {
+	case *ast.ExprStmt:
+		return x
+	case *ast.DeferStmt:
+		return x
+	}
+	return nil
+}
+
+// removeSyncCalls tries to remove all the calls to sync methods on the
+// object that a call is made on, such as all the Lock and Unlock calls
+// on a mutex. They are removed at once, as removing only some of them
+// would likely cause a deadlock.
+func (r *reducer) removeSyncCalls(ce *ast.CallExpr) bool {
+	obj := r.syncMethod(ce)
+	if obj == nil {
+		return false
+	}
+	var stmts []ast.Stmt
+	for _, use := range r.useIdents[obj] {
+		if r.nodeFile(use) != r.file {
+			continue
+		}
+		stmt := r.syncCallStmt(obj, use)
+		if stmt == nil {
+			continue
+		}
+		if r.parentStmts(stmt) == nil {
+			return false
+		}
+		stmts = append(stmts, stmt)
+	}
+	if len(stmts) == 0 {
+		return false
+	}
+	var undos []func()
+	deleted := make([]ast.Node, len(stmts))
+	for i, stmt := range stmts {
+		undos = append(undos, r.replaceStmts(stmt, nil))
+		deleted[i] = stmt
+	}
+	r.afterDelete(deleted...)
+	if !r.okChange() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+		return false
+	}
+	for _, stmt := range stmts {
+		r.mergeLines(stmt.Pos(), stmt.End()+1)
+	}
+	r.logChange(ce, "removed sync calls on %s", obj.Name())
+	return true
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// runResult is the outcome of running the shell code once.
+type runResult struct {
+	out    []byte // stdout and stderr, combined
+	stdout []byte
+	stderr []byte
+
+	exit     int // 128+n if killed by signal n
+	timedOut bool
+}
+
+// predicate checks whether a run is interesting, returning an error
+// describing why it isn't otherwise.
+type predicate func(res *runResult) error
+
+// outWriter writes to its own buffer as well as to a buffer shared with
+// other writers, so that stdout and stderr can be kept apart as well as
+// combined.
+type outWriter struct {
+	mu       *sync.Mutex
+	own, all *bytes.Buffer
+}
+
+func (w outWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.own.Write(p)
+	return w.all.Write(p)
+}
+
+// outWriters returns the writers for stdout and stderr, which fill the
+// output fields of res.
+func outWriters(res *runResult) (stdout, stderr io.Writer, done func()) {
+	var mu sync.Mutex
+	var out, sout, serr bytes.Buffer
+	return outWriter{&mu, &sout, &out}, outWriter{&mu, &serr, &out}, func() {
+		res.out, res.stdout, res.stderr = out.Bytes(), sout.Bytes(), serr.Bytes()
+	}
+}
+
+// signals are the signal names accepted by -exit.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGILL":  syscall.SIGILL,
+	"SIGTRAP": syscall.SIGTRAP,
+	"SIGABRT": syscall.SIGABRT,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGPIPE": syscall.SIGPIPE,
+	"SIGALRM": syscall.SIGALRM,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// exitPredicate parses the comma-separated list of exit statuses given
+// to -exit, any of which makes a run interesting. Each of them can be a
+// number, a signal name such as SIGSEGV, or "nonzero".
+func exitPredicate(list string) (predicate, error) {
+	var codes []int
+	nonZero := false
+	for _, s := range strings.Split(list, ",") {
+		if s == "nonzero" {
+			nonZero = true
+			continue
+		}
+		if sig, ok := signals[strings.ToUpper(s)]; ok {
+			codes = append(codes, 128+int(sig))
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("invalid exit status: %q", s)
+		}
+		codes = append(codes, code)
+	}
+	return func(res *runResult) error {
+		if nonZero && res.exit != 0 {
+			return nil
+		}
+		for _, code := range codes {
+			if res.exit == code {
+				return nil
+			}
+		}
+		return fmt.Errorf("exit status %d does not match", res.exit)
+	}, nil
+}
+
+// regexpPredicate returns a predicate checking that the output selected
+// by out matches re.
+func regexpPredicate(re *regexp.Regexp, name string, out func(res *runResult) []byte) predicate {
+	return func(res *runResult) error {
+		if b := out(res); !re.Match(b) {
+			return fmt.Errorf("%s does not match:\n%s", name, string(b))
+		}
+		return nil
+	}
+}
+
+// setupPredicates builds the predicates from the match regexp and the
+// flags. At least one of them must be given, unless -hang is used, as
+// timing out is what makes a run interesting then.
+func (r *reducer) setupPredicates(match string) error {
+	if match != "" && *hang {
+		// the output before timing out must match, even if empty
+		r.preds = append(r.preds, func(res *runResult) error {
+			if !r.matchRe.Match(res.out) {
+				return fmt.Errorf("output before timing out does not match:\n%s", string(res.out))
+			}
+			return nil
+		})
+	} else if match != "" {
+		r.preds = append(r.preds, func(res *runResult) error {
+			if len(res.out) == 0 {
+				return fmt.Errorf("expected an error to occur")
+			}
+			if !r.matchRe.Match(res.out) {
+				return fmt.Errorf("error does not match:\n%s", string(res.out))
+			}
+			return nil
+		})
+	}
+	for _, rx := range [...]struct {
+		expr, name string
+		out        func(res *runResult) []byte
+	}{
+		{*stdoutStr, "stdout", func(res *runResult) []byte { return res.stdout }},
+		{*stderrStr, "stderr", func(res *runResult) []byte { return res.stderr }},
+	} {
+		if rx.expr == "" {
+			continue
+		}
+		re, err := regexp.Compile(rx.expr)
+		if err != nil {
+			return err
+		}
+		r.preds = append(r.preds, regexpPredicate(re, rx.name, rx.out))
+	}
+	if *exitStr != "" {
+		if *hang {
+			return fmt.Errorf("-exit cannot be used with -hang, as the shell code is killed")
+		}
+		pred, err := exitPredicate(*exitStr)
+		if err != nil {
+			return err
+		}
+		r.preds = append(r.preds, pred)
+	}
+	if *notMatchStr != "" {
+		re, err := regexp.Compile(*notMatchStr)
+		if err != nil {
+			return err
+		}
+		r.notMatchRe = re
+	}
+	if len(r.preds) == 0 && !*hang {
+		return fmt.Errorf("nothing to match the result of the shell code against")
+	}
+	return nil
+}
+
+// checkResult reports whether a run is interesting. All the predicates
+// must hold, or just one of them with -any; there is no grouping. Regardless, the output must
+// not match -not-match, so that the reduction doesn't drift into a
+// different bug.
+func (r *reducer) checkResult(res *runResult) error {
+	if r.notMatchRe != nil && r.notMatchRe.Match(res.out) {
+		return fmt.Errorf("output matches -not-match:\n%s", string(res.out))
+	}
+	var firstErr error
+	for _, pred := range r.preds {
+		err := pred(res)
+		if err == nil && *anyPred {
+			return nil
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
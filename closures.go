@@ -0,0 +1,407 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// litBody returns a copy of the body of a func literal called right
+// away, placed at pos and without its final return statement, along
+// with the expression it returns. It returns nil if the func literal
+// returns anywhere else, or if it defers calls, as they would then run
+// at the end of the outer func.
+func litBody(lit *ast.FuncLit, pos token.Pos) (*ast.BlockStmt, ast.Expr) {
+	if anyDefers(lit.Body) {
+		return nil, nil
+	}
+	body := copyNode(lit.Body, pos).(*ast.BlockStmt)
+	var result ast.Expr
+	if n := len(body.List); n > 0 {
+		if ret, _ := body.List[n-1].(*ast.ReturnStmt); ret != nil {
+			if len(ret.Results) > 1 {
+				return nil, nil
+			}
+			if len(ret.Results) == 1 {
+				result = ret.Results[0]
+			}
+			body.List = body.List[:n-1]
+		}
+	}
+	if len(funcReturns(body)) > 0 {
+		return nil, nil
+	}
+	return body, result
+}
+
+// anyDefers reports whether a func body contains defer statements,
+// outside of any func literals.
+func anyDefers(body *ast.BlockStmt) (found bool) {
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.DeferStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// calledLit returns the func literal called by ce, along with its body
+// as given by litBody, if it can be inlined where it's called.
+func (r *reducer) calledLit(ce *ast.CallExpr) (*ast.FuncLit, *ast.BlockStmt, ast.Expr) {
+	lit, _ := ce.Fun.(*ast.FuncLit)
+	if lit == nil {
+		return nil, nil, nil
+	}
+	if ftype, _ := r.inlinable(ce); ftype == nil {
+		return nil, nil, nil
+	}
+	body, result := litBody(lit, ce.Pos())
+	if body == nil {
+		return nil, nil, nil
+	}
+	return lit, body, result
+}
+
+// inlineLitStmt tries to replace a func literal called right away, as in
+// func() { ...; return }(), by a block with its body. Func literals with
+// no return statements are left to the rules inlining calls.
+func (r *reducer) inlineLitStmt(es *ast.ExprStmt, ce *ast.CallExpr) bool {
+	lit, body, result := r.calledLit(ce)
+	if lit == nil || result != nil || len(funcReturns(lit.Body)) == 0 {
+		return false
+	}
+	stmts, ok := r.paramBindings(lit.Type, ce.Args, ce.Pos())
+	if !ok {
+		return false
+	}
+	block := &ast.BlockStmt{
+		Lbrace: es.Pos(),
+		List:   append(stmts, body.List...),
+		Rbrace: es.End(),
+	}
+	ref := r.stmtRef(es)
+	if ref == nil {
+		return false
+	}
+	*ref = block
+	r.afterDelete(lit)
+	if !r.okChange() {
+		*ref = es
+		return false
+	}
+	r.setParents(block, r.parents[es])
+	return true
+}
+
+// inlineLitAssign tries to replace an assignment of the result of a func
+// literal called right away, as in x := func() T { ...; return v }(), by
+// a block with its body which ends with x = v.
+func (r *reducer) inlineLitAssign(as *ast.AssignStmt) bool {
+	if len(as.Lhs) != 1 || len(as.Rhs) != 1 || r.parentStmts(as) == nil {
+		return false
+	}
+	id, _ := as.Lhs[0].(*ast.Ident)
+	ce, _ := as.Rhs[0].(*ast.CallExpr)
+	if id == nil || id.Name == "_" || ce == nil {
+		return false
+	}
+	lit, body, result := r.calledLit(ce)
+	if lit == nil || result == nil || mentions(ce, id.Name) {
+		return false
+	}
+	results := lit.Type.Results
+	if len(results.List) != 1 || len(results.List[0].Names) > 0 {
+		return false
+	}
+	stmts, ok := r.paramBindings(lit.Type, ce.Args, ce.Pos())
+	if !ok {
+		return false
+	}
+	pos := as.Pos()
+	body.List = append(stmts, body.List...)
+	body.List = append(body.List, &ast.AssignStmt{
+		Lhs:    []ast.Expr{&ast.Ident{NamePos: pos, Name: id.Name}},
+		TokPos: pos,
+		Tok:    token.ASSIGN,
+		Rhs:    []ast.Expr{result},
+	})
+	body.Lbrace, body.Rbrace = pos, as.End()
+	var with []ast.Stmt
+	if as.Tok == token.DEFINE {
+		with = append(with, &ast.DeclStmt{Decl: &ast.GenDecl{
+			TokPos: pos,
+			Tok:    token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{
+				Names: []*ast.Ident{{NamePos: pos, Name: id.Name}},
+				Type:  copyNode(results.List[0].Type, pos).(ast.Expr),
+			}},
+		}})
+	}
+	with = append(with, body)
+	parent := r.parents[as]
+	undo := r.replaceStmts(as, with)
+	r.afterDelete(lit)
+	if !r.okChange() {
+		undo()
+		return false
+	}
+	for _, stmt := range with {
+		r.setParents(stmt, parent)
+	}
+	return true
+}
+
+// capturesNothing reports whether a func literal only uses names which
+// are declared within it or at the package level, so that it could be a
+// top-level func in the same file.
+func (r *reducer) capturesNothing(lit *ast.FuncLit) bool {
+	scope := r.filePkg(r.file).types.Scope()
+	captures := false
+	ast.Inspect(lit, func(node ast.Node) bool {
+		id, _ := node.(*ast.Ident)
+		obj := r.info.Uses[id]
+		if obj == nil || captures {
+			return !captures
+		}
+		if obj.Pos() >= lit.Pos() && obj.Pos() < lit.End() {
+			return true // declared within
+		}
+		switch parent := obj.Parent(); {
+		case parent == nil: // field, method or label
+		case parent == scope, parent == types.Universe:
+		case parent.Parent() == scope: // imports are per file
+		default:
+			captures = true
+		}
+		return true
+	})
+	return !captures
+}
+
+// hoistLit tries to move a func literal which captures nothing within a
+// func to a new top-level func right after it, using its name instead.
+func (r *reducer) hoistLit(lit *ast.FuncLit) bool {
+	var decl ast.Node = lit
+	for decl != nil && r.parents[decl] != r.file {
+		decl = r.parents[decl]
+	}
+	fd, _ := decl.(*ast.FuncDecl)
+	if fd == nil || !r.capturesNothing(lit) {
+		return false
+	}
+	var expr ast.Expr = lit
+	for {
+		pe, _ := r.parents[expr].(*ast.ParenExpr)
+		if pe == nil {
+			break
+		}
+		expr = pe // (func() {...}) -> f
+	}
+	ref := r.exprRef(expr)
+	if ref == nil {
+		return false
+	}
+	lp := r.filePkg(r.file)
+	name := ""
+	for i := 0; name == ""; i++ {
+		if s := shortName(funcNames, i); !r.nameTaken(s, lp, lit.Pos()) {
+			name = s
+		}
+	}
+	newFd := &ast.FuncDecl{
+		Name: &ast.Ident{Name: name},
+		Type: copyNode(lit.Type, token.NoPos).(*ast.FuncType),
+		Body: copyNode(lit.Body, token.NoPos).(*ast.BlockStmt),
+	}
+	oldDecls := r.file.Decls
+	var decls []ast.Decl
+	for _, decl := range r.file.Decls {
+		decls = append(decls, decl)
+		if decl == fd {
+			decls = append(decls, newFd)
+		}
+	}
+	r.file.Decls = decls
+	id := &ast.Ident{NamePos: expr.Pos(), Name: name}
+	*ref = id
+	r.afterDelete(expr)
+	if !r.okChange() {
+		r.file.Decls = oldDecls
+		*ref = expr
+		return false
+	}
+	r.mergeLines(expr.Pos(), expr.End())
+	r.parents[id] = r.parents[expr]
+	r.setParents(newFd, r.file)
+	return true
+}
+
+// funcValue reports whether an expression is a func or a func literal,
+// as opposed to a func value that could change.
+func (r *reducer) funcValue(e ast.Expr) bool {
+	switch x := ast.Unparen(e).(type) {
+	case *ast.FuncLit:
+		return true
+	case *ast.Ident:
+		fn, _ := r.info.Uses[x].(*types.Func)
+		return fn != nil && fn.Type().(*types.Signature).Recv() == nil
+	case *ast.SelectorExpr: // pkg.Func
+		fn, _ := r.info.Uses[x.Sel].(*types.Func)
+		return fn != nil && r.info.Selections[x] == nil
+	}
+	return false
+}
+
+// inlineFuncVar tries to replace a var of func type by the func it holds,
+// when it's never assigned another one. A func literal is only put in
+// place of a single use.
+func (r *reducer) inlineFuncVar(id *ast.Ident) bool {
+	obj, _ := r.info.Defs[id].(*types.Var)
+	if obj == nil || obj.IsField() || !r.removableName(id) {
+		return false
+	}
+	if _, ok := obj.Type().Underlying().(*types.Signature); !ok {
+		return false
+	}
+	val := r.declIdentValue(id)
+	if val == nil || !r.funcValue(val) {
+		return false
+	}
+	if vs, _ := r.parents[id].(*ast.ValueSpec); vs != nil && len(vs.Names) > 1 {
+		return false
+	}
+	uses := r.useIdents[obj]
+	lit, _ := ast.Unparen(val).(*ast.FuncLit)
+	if len(uses) == 0 || lit != nil && len(uses) > 1 {
+		return false
+	}
+	var undos []func()
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	var added []ast.Expr
+	var nodes []ast.Node
+	for _, use := range uses {
+		switch x := r.parents[use].(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range x.Lhs {
+				if lhs == use { // assigned another func
+					undoAll()
+					return false
+				}
+			}
+		case *ast.UnaryExpr:
+			if x.Op == token.AND { // could be assigned via a pointer
+				undoAll()
+				return false
+			}
+		}
+		if lit != nil && !r.sameNames(lit.Type, lit.Body, use.Pos()) {
+			undoAll()
+			return false
+		}
+		if val, ok := val.(*ast.Ident); ok {
+			scope := r.filePkg(r.nodeFile(use)).types.Scope().Innermost(use.Pos())
+			if scope == nil {
+				undoAll()
+				return false
+			}
+			if _, found := scope.LookupParent(val.Name, use.Pos()); found != r.info.Uses[val] {
+				undoAll()
+				return false
+			}
+		}
+		ref := r.exprRef(use)
+		if ref == nil {
+			undoAll()
+			return false
+		}
+		with := copyNode(val, use.Pos()).(ast.Expr)
+		*ref = with
+		use := use
+		undos = append(undos, func() { *ref = use })
+		added = append(added, with)
+		nodes = append(nodes, use)
+	}
+	var decl ast.Node = r.parents[id] // to merge its lines
+	switch x := decl.(type) {
+	case *ast.AssignStmt:
+		if len(x.Lhs) > 1 {
+			decl = nil
+		}
+	case *ast.ValueSpec:
+		if gd := r.parents[x].(*ast.GenDecl); len(gd.Specs) == 1 {
+			decl = gd
+		}
+	}
+	var start, end token.Pos
+	if decl != nil {
+		start, end = decl.Pos(), decl.End()
+	}
+	undo := r.removeDecl(id)
+	if undo == nil {
+		undoAll()
+		return false
+	}
+	undos = append(undos, undo)
+	if !r.tryEdits(nodes, nil, undos) {
+		return false
+	}
+	if decl != nil {
+		r.mergeLines(start, end+1)
+	}
+	for i, with := range added {
+		r.setParents(with, r.parents[nodes[i]])
+	}
+	return true
+}
+
+// removeRecoverDefer tries to remove a defer statement from a list which
+// runs a func literal calling recover, before any other statements, as
+// the program might be more interesting if the panic isn't stopped.
+func (r *reducer) removeRecoverDefer(list *[]ast.Stmt) bool {
+	for _, stmt := range *list {
+		ds, _ := stmt.(*ast.DeferStmt)
+		if ds == nil || !r.recoverDefer(ds) {
+			continue
+		}
+		r.afterDelete(ds)
+		if r.replacedStmts(ds, nil) {
+			r.logChange(ds, "defer recover removed")
+			return true
+		}
+	}
+	return false
+}
+
+// recoverDefer reports whether a defer statement runs a func literal
+// which calls recover, stopping a panic.
+func (r *reducer) recoverDefer(ds *ast.DeferStmt) bool {
+	lit, _ := ds.Call.Fun.(*ast.FuncLit)
+	if lit == nil {
+		return false
+	}
+	found := false
+	ast.Inspect(lit.Body, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			id, _ := x.Fun.(*ast.Ident)
+			if bt, _ := r.info.Uses[id].(*types.Builtin); bt != nil && bt.Name() == "recover" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
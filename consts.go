@@ -0,0 +1,397 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// resolveConst returns a literal for a constant expression, using the
+// value computed by go/types. It returns nil if the expression isn't
+// constant, if it's already as simple as it gets, or if its value can't
+// be written as a literal without changing its meaning.
+func (r *reducer) resolveConst(e ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.BasicLit, *ast.Ident:
+		return nil // already a literal, or left to the inlining rules
+	case *ast.CallExpr:
+		if len(x.Args) == 1 && r.isType(x.Fun) {
+			if _, ok := x.Args[0].(*ast.BasicLit); ok {
+				return nil // T(lit), already resolved
+			}
+		}
+	case *ast.UnaryExpr:
+		if _, ok := x.X.(*ast.BasicLit); ok && x.Op == token.SUB {
+			return nil // -lit, already resolved
+		}
+	}
+	tv, ok := r.info.Types[e]
+	if !ok || tv.Value == nil || r.usesIota(e) {
+		return nil
+	}
+	return r.valueLit(tv.Value, tv.Type, r.inherentlyTyped(e), e.Pos())
+}
+
+// isType reports whether an expression denotes a type.
+func (r *reducer) isType(e ast.Expr) bool {
+	tv, ok := r.info.Types[e]
+	return ok && tv.IsType()
+}
+
+// usesIota reports whether an expression uses iota, in which case its
+// value depends on the spec it's in. Specs without values repeat the
+// expression of the previous spec, so it must stay.
+func (r *reducer) usesIota(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(node ast.Node) bool {
+		if id, _ := node.(*ast.Ident); id != nil && r.info.Uses[id] == types.Universe.Lookup("iota") {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// inherentlyTyped reports whether a constant expression has a type of
+// its own, given by a typed constant or a conversion, rather than the one
+// given by the context it's used in.
+func (r *reducer) inherentlyTyped(e ast.Expr) bool {
+	typed := false
+	ast.Inspect(e, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.Ident:
+			if c, _ := r.info.Uses[x].(*types.Const); c != nil && !isUntyped(c.Type()) {
+				typed = true
+			}
+		case *ast.CallExpr:
+			if r.isType(x.Fun) || !isUntyped(r.info.TypeOf(x)) {
+				typed = true // T(x), or a builtin like len
+			}
+			return false
+		}
+		return !typed
+	})
+	return typed
+}
+
+// isUntyped reports whether a type is that of an untyped constant.
+func isUntyped(t types.Type) bool {
+	b, _ := t.(*types.Basic)
+	return b != nil && b.Info()&types.IsUntyped != 0
+}
+
+// valueLit returns a literal for a constant value of a type. If typed is
+// true, the value keeps its type regardless of the context, so the
+// literal is converted to the type if it would have another one.
+func (r *reducer) valueLit(val constant.Value, t types.Type, typed bool, pos token.Pos) ast.Expr {
+	basic, _ := t.Underlying().(*types.Basic)
+	if basic == nil {
+		return nil
+	}
+	var lit ast.Expr
+	var litType types.Type
+	info := basic.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		name := strconv.FormatBool(constant.BoolVal(val))
+		if !r.universeName(name, pos) {
+			return nil // shadowed
+		}
+		lit = &ast.Ident{NamePos: pos, Name: name}
+		litType = types.Typ[types.Bool]
+	case info&types.IsString != 0:
+		s := constant.StringVal(val)
+		lit = &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(s)}
+		litType = types.Typ[types.String]
+	case info&types.IsInteger != 0:
+		val = constant.ToInt(val)
+		if val.Kind() != constant.Int {
+			return nil
+		}
+		litType = types.Typ[types.Int]
+		switch basic.Kind() {
+		case types.Int32, types.UntypedRune, types.Uint8:
+			n, ok := constant.Int64Val(val)
+			if ok && n >= 0 && n <= utf8.MaxRune && utf8.ValidRune(rune(n)) &&
+				(basic.Kind() != types.Uint8 || n < utf8.RuneSelf && unicode.IsPrint(rune(n))) {
+				lit = &ast.BasicLit{ValuePos: pos, Kind: token.CHAR, Value: strconv.QuoteRune(rune(n))}
+				litType = types.Typ[types.Rune]
+			}
+		}
+		if lit == nil {
+			lit = numberLit(token.INT, val.ExactString(), pos)
+		}
+	case info&types.IsFloat != 0:
+		s, ok := floatString(val, basic)
+		if !ok {
+			return nil
+		}
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		lit = numberLit(token.FLOAT, s, pos)
+		litType = types.Typ[types.Float64]
+	case info&types.IsComplex != 0:
+		val = constant.ToComplex(val)
+		if val.Kind() != constant.Complex {
+			return nil
+		}
+		re, ok1 := floatString(constant.Real(val), basic)
+		im, ok2 := floatString(constant.Imag(val), basic)
+		if !ok1 || !ok2 {
+			return nil
+		}
+		imag := numberLit(token.IMAG, im+"i", pos)
+		if constant.Sign(constant.Real(val)) == 0 {
+			lit = imag
+		} else {
+			lit = &ast.ParenExpr{Lparen: pos, X: &ast.BinaryExpr{
+				X:     numberLit(token.FLOAT, re, pos),
+				OpPos: pos,
+				Op:    token.ADD,
+				Y:     imag,
+			}, Rparen: pos}
+		}
+		litType = types.Typ[types.Complex128]
+	default:
+		return nil
+	}
+	if !typed || isUntyped(t) || types.Identical(t, litType) {
+		return lit
+	}
+	typ := r.typeExpr(t, pos)
+	if typ == nil {
+		return nil
+	}
+	return &ast.CallExpr{Fun: typ, Lparen: pos, Args: []ast.Expr{lit}, Rparen: pos}
+}
+
+// numberLit returns a number literal, or its negation if s starts with
+// a minus sign.
+func numberLit(kind token.Token, s string, pos token.Pos) ast.Expr {
+	if strings.HasPrefix(s, "-") {
+		return &ast.UnaryExpr{OpPos: pos, Op: token.SUB, X: numberLit(kind, s[1:], pos)}
+	}
+	return &ast.BasicLit{ValuePos: pos, Kind: kind, Value: s}
+}
+
+// floatString returns the representation of a constant as a number
+// literal for a float or complex type. Untyped constants must be written
+// exactly, which isn't possible for 1.0/3; typed ones only need to
+// round to the same value.
+func floatString(val constant.Value, basic *types.Basic) (string, bool) {
+	val = constant.ToFloat(val)
+	if val.Kind() != constant.Float {
+		return "", false
+	}
+	switch basic.Kind() {
+	case types.Float32, types.Complex64:
+		if f, exact := constant.Float32Val(val); exact {
+			return strconv.FormatFloat(float64(f), 'g', -1, 32), true
+		}
+	case types.Float64, types.Complex128:
+		if f, exact := constant.Float64Val(val); exact {
+			return strconv.FormatFloat(f, 'g', -1, 64), true
+		}
+	}
+	switch x := constant.Val(val).(type) {
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case *big.Int:
+		return x.String(), true
+	case *big.Rat:
+		// a fraction has a finite decimal representation only if its
+		// denominator has no prime factors other than 2 and 5
+		den := new(big.Int).Set(x.Denom())
+		digits := 0
+		for _, p := range []int64{2, 5} {
+			n := 0
+			for m := new(big.Int); ; n++ {
+				q, rem := new(big.Int).QuoRem(den, big.NewInt(p), m)
+				if rem.Sign() != 0 {
+					break
+				}
+				den = q
+			}
+			if n > digits {
+				digits = n
+			}
+		}
+		if den.Cmp(big.NewInt(1)) == 0 {
+			return x.FloatString(digits), true
+		}
+	}
+	return "", false // too large, or not exact
+}
+
+// universeName reports whether a name refers to the universe object by
+// that name at a position, i.e. whether it isn't shadowed.
+func (r *reducer) universeName(name string, pos token.Pos) bool {
+	pkg := r.filePkg(r.file).types
+	scope := pkg.Scope().Innermost(pos)
+	if scope == nil {
+		scope = pkg.Scope()
+	}
+	_, obj := scope.LookupParent(name, pos)
+	return obj == types.Universe.Lookup(name)
+}
+
+// unaryOp computes a unary operation on a constant value of a type,
+// returning nil if it's not valid.
+func unaryOp(op token.Token, x constant.Value, t types.Type) constant.Value {
+	prec := uint(0)
+	switch op {
+	case token.ADD, token.SUB:
+		switch x.Kind() {
+		case constant.Int, constant.Float, constant.Complex:
+		default:
+			return nil
+		}
+	case token.NOT:
+		if x.Kind() != constant.Bool {
+			return nil
+		}
+	case token.XOR:
+		if x.Kind() != constant.Int {
+			return nil
+		}
+		if basic, _ := t.Underlying().(*types.Basic); basic != nil && basic.Info()&types.IsUnsigned != 0 {
+			if prec = intBits(basic); prec == 0 {
+				return nil // depends on the platform
+			}
+		}
+	default:
+		return nil
+	}
+	return constant.UnaryOp(op, x, prec)
+}
+
+// intBits returns the size in bits of an integer type, or 0 if it
+// depends on the platform or it's untyped.
+func intBits(basic *types.Basic) uint {
+	switch basic.Kind() {
+	case types.Int8, types.Uint8:
+		return 8
+	case types.Int16, types.Uint16:
+		return 16
+	case types.Int32, types.Uint32:
+		return 32
+	case types.Int64, types.Uint64:
+		return 64
+	}
+	return 0
+}
+
+// representable reports whether a value computed by goreduce is that of
+// an expression of a type, meaning that it didn't overflow or lose
+// precision. Values of types whose size depends on the platform are kept
+// to 32 bits.
+func representable(val constant.Value, t types.Type) bool {
+	basic, _ := t.Underlying().(*types.Basic)
+	if basic == nil {
+		return false
+	}
+	info := basic.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		return val.Kind() == constant.Bool
+	case info&types.IsString != 0:
+		return val.Kind() == constant.String
+	case info&types.IsInteger != 0:
+		if val.Kind() != constant.Int {
+			return false
+		}
+		if info&types.IsUntyped != 0 {
+			return true
+		}
+		bits := intBits(basic)
+		if bits == 0 {
+			bits = 32
+		}
+		if info&types.IsUnsigned != 0 {
+			max := constant.Shift(constant.MakeInt64(1), token.SHL, bits)
+			return constant.Sign(val) >= 0 && constant.Compare(val, token.LSS, max)
+		}
+		max := constant.Shift(constant.MakeInt64(1), token.SHL, bits-1)
+		return constant.Compare(val, token.LSS, max) &&
+			constant.Compare(val, token.GEQ, constant.UnaryOp(token.SUB, max, 0))
+	case info&types.IsFloat != 0:
+		// float32 values would need rounding
+		return val.Kind() == constant.Float && basic.Kind() != types.Float32
+	case info&types.IsComplex != 0:
+		return val.Kind() == constant.Complex && basic.Kind() != types.Complex64
+	}
+	return false
+}
+
+// binaryOp computes a binary operation on two constant values, returning
+// nil if it's not valid, such as a division by zero.
+func binaryOp(x constant.Value, op token.Token, y constant.Value) constant.Value {
+	numeric := func(v constant.Value) bool {
+		switch v.Kind() {
+		case constant.Int, constant.Float, constant.Complex:
+			return true
+		}
+		return false
+	}
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if x.Kind() != y.Kind() && !(numeric(x) && numeric(y)) {
+			return nil
+		}
+		if x.Kind() == constant.Complex || y.Kind() == constant.Complex {
+			if op != token.EQL && op != token.NEQ {
+				return nil
+			}
+		}
+		if x.Kind() == constant.Bool && op != token.EQL && op != token.NEQ {
+			return nil
+		}
+		return constant.MakeBool(constant.Compare(x, op, y))
+	case token.SHL, token.SHR:
+		s, ok := constant.Uint64Val(y)
+		if x.Kind() != constant.Int || y.Kind() != constant.Int || !ok || s > 1<<10 {
+			return nil
+		}
+		return constant.Shift(x, op, uint(s))
+	case token.LAND, token.LOR:
+		if x.Kind() != constant.Bool || y.Kind() != constant.Bool {
+			return nil
+		}
+	case token.ADD:
+		if x.Kind() == constant.String && y.Kind() == constant.String {
+			return constant.BinaryOp(x, op, y)
+		}
+		fallthrough
+	case token.SUB, token.MUL:
+		if !numeric(x) || !numeric(y) {
+			return nil
+		}
+	case token.QUO:
+		if !numeric(x) || !numeric(y) || constant.Sign(y) == 0 {
+			return nil
+		}
+		if x.Kind() == constant.Int && y.Kind() == constant.Int {
+			op = token.QUO_ASSIGN // integer division
+		}
+	case token.REM, token.AND, token.OR, token.XOR, token.AND_NOT:
+		if x.Kind() != constant.Int || y.Kind() != constant.Int {
+			return nil
+		}
+		if op == token.REM && constant.Sign(y) == 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return constant.BinaryOp(x, op, y)
+}
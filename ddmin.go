@@ -0,0 +1,128 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// chunks calls fn with the ranges of a list of n elements split in
+// halves, then in quarters and so on, down to chunks of min elements.
+// It stops as soon as fn returns true, which it returns too.
+//
+// Like delta debugging, this removes large parts of a list with few
+// tries when they aren't needed, which is much faster than removing
+// elements one at a time.
+func chunks(n, min int, fn func(start, end int) bool) bool {
+	for size := n / 2; size >= min && size > 0; size /= 2 {
+		for start := 0; start < n; start += size {
+			end := start + size
+			if end > n {
+				end = n
+			}
+			if end-start < min {
+				break // left to the rules removing single elements
+			}
+			if fn(start, end) {
+				return true
+			}
+		}
+		if size > min && size/2 < min {
+			size = min * 2 // try chunks of min elements last
+		}
+	}
+	return false
+}
+
+// usedOutside reports whether any of the objects declared within nodes
+// is used anywhere else, in which case removing the nodes can't work.
+func (r *reducer) usedOutside(nodes ...ast.Node) bool {
+	inside := make(map[*ast.Ident]bool)
+	for _, node := range nodes {
+		ast.Inspect(node, func(node ast.Node) bool {
+			if id, _ := node.(*ast.Ident); id != nil {
+				inside[id] = true
+			}
+			return true
+		})
+	}
+	for id := range inside {
+		obj := r.info.Defs[id]
+		if obj == nil {
+			continue
+		}
+		for _, use := range r.useIdents[obj] {
+			if !inside[use] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeStmtChunks tries to remove chunks of at least two statements
+// from a list, starting with its halves.
+func (r *reducer) removeStmtChunks(list *[]ast.Stmt) bool {
+	orig := *list
+	return chunks(len(orig), 2, func(start, end int) bool {
+		removed := make([]ast.Node, 0, end-start)
+		for _, stmt := range orig[start:end] {
+			removed = append(removed, stmt)
+		}
+		if r.usedOutside(removed...) {
+			return false
+		}
+		*list = append(orig[:start:start], orig[end:]...)
+		r.afterDelete(removed...)
+		if r.okChange() {
+			if end < len(orig) {
+				r.mergeLines(orig[start].Pos(), orig[end].Pos())
+			} else {
+				r.mergeLines(orig[start].Pos(), orig[end-1].End()+1)
+			}
+			r.logChange(orig[start], "%d stmts removed", end-start)
+			return true
+		}
+		*list = orig
+		return false
+	})
+}
+
+// removeDeclChunks tries to remove chunks of at least two top-level
+// declarations from a file, other than imports, starting with its halves.
+func (r *reducer) removeDeclChunks(f *ast.File) bool {
+	var decls []ast.Decl
+	for _, decl := range f.Decls {
+		if gd, _ := decl.(*ast.GenDecl); gd == nil || gd.Tok != token.IMPORT {
+			decls = append(decls, decl)
+		}
+	}
+	return chunks(len(decls), 2, func(start, end int) bool {
+		removed := make([]ast.Node, 0, end-start)
+		for _, decl := range decls[start:end] {
+			if isMainFunc(f, decl) || !r.removableDecl(decl) {
+				return false
+			}
+			removed = append(removed, decl)
+		}
+		if r.usedOutside(removed...) {
+			return false
+		}
+		from, to := declPos(decls[start]), decls[end-1].End()
+		undo := r.removeDecls(f, decls[start:end]...)
+		r.afterDelete(removed...)
+		if r.okChange() {
+			if end < len(decls) {
+				r.mergeLines(from, declPos(decls[end]))
+			} else {
+				r.mergeLines(from, to+1)
+			}
+			r.logChange(decls[start], "%d decls removed", end-start)
+			return true
+		}
+		undo()
+		return false
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// removableName reports whether the declaration of a name may be removed
+// when it has no uses. Exported names may be used by other packages, so
+// they are only removed from main packages, or with -exported.
+func (r *reducer) removableName(id *ast.Ident) bool {
+	if !ast.IsExported(id.Name) || *exported {
+		return true
+	}
+	return r.file.Name.Name == "main"
+}
+
+// removableDecl reports whether all the names declared at the top level
+// by decl may be removed.
+func (r *reducer) removableDecl(decl ast.Decl) bool {
+	switch x := decl.(type) {
+	case *ast.FuncDecl:
+		return r.removableName(x.Name)
+	case *ast.GenDecl:
+		for _, spec := range x.Specs {
+			switch x := spec.(type) {
+			case *ast.ValueSpec:
+				for _, name := range x.Names {
+					if !r.removableName(name) {
+						return false
+					}
+				}
+			case *ast.TypeSpec:
+				if !r.removableName(x.Name) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// isMainFunc reports whether a declaration is the main func of a main
+// package, which can never be removed.
+func isMainFunc(f *ast.File, decl ast.Decl) bool {
+	fd, _ := decl.(*ast.FuncDecl)
+	return fd != nil && fd.Recv == nil && fd.Name.Name == "main" &&
+		f.Name.Name == "main"
+}
+
+// removeDecls removes top-level declarations from a file, along with the
+// comments within them.
+func (r *reducer) removeDecls(f *ast.File, decls ...ast.Decl) (undo func()) {
+	oldDecls, oldComments := f.Decls, f.Comments
+	f.Decls = nil
+	for _, decl := range oldDecls {
+		removed := false
+		for _, decl2 := range decls {
+			if decl == decl2 {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			f.Decls = append(f.Decls, decl)
+		}
+	}
+	f.Comments = nil
+	for _, cg := range oldComments {
+		removed := false
+		for _, decl := range decls {
+			if cg.Pos() >= declPos(decl) && cg.End() <= decl.End() {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			f.Comments = append(f.Comments, cg)
+		}
+	}
+	return func() {
+		f.Decls, f.Comments = oldDecls, oldComments
+	}
+}
+
+// mergeDeclLines merges the lines of a removed declaration, unless a
+// declaration that is left shares its positions, such as an instantiated
+// copy of a generic func.
+func (r *reducer) mergeDeclLines(f *ast.File, removed ast.Decl) {
+	from, to := declPos(removed), removed.End()+1
+	for _, decl := range f.Decls {
+		if decl.Pos() < to && decl.End() > from {
+			return
+		}
+	}
+	r.mergeLines(from, to)
+}
+
+// declPos returns the position where a declaration starts, including
+// its doc comment.
+func declPos(decl ast.Decl) token.Pos {
+	switch x := decl.(type) {
+	case *ast.FuncDecl:
+		if x.Doc != nil {
+			return x.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if x.Doc != nil {
+			return x.Doc.Pos()
+		}
+	}
+	return decl.Pos()
+}
+
+// removeFuncDecl tries to remove a func or method which isn't used
+// anywhere, including init funcs.
+func (r *reducer) removeFuncDecl(fd *ast.FuncDecl) bool {
+	f := r.parents[fd].(*ast.File)
+	if isMainFunc(f, fd) || !r.removableName(fd.Name) || r.usedOutside(fd) {
+		return false
+	}
+	undo := r.removeDecls(f, fd)
+	r.afterDelete(fd)
+	if !r.okChange() {
+		undo()
+		return false
+	}
+	r.mergeDeclLines(f, fd)
+	switch {
+	case fd.Recv != nil:
+		r.logChange(fd, "removed method decl")
+	case fd.Name.Name == "init":
+		r.logChange(fd, "removed init func")
+	default:
+		r.logChange(fd, "removed func decl")
+	}
+	return true
+}
+
+// typeMethods returns the method declarations of a top-level type.
+func (r *reducer) typeMethods(ts *ast.TypeSpec) []*ast.FuncDecl {
+	obj := r.info.Defs[ts.Name]
+	var methods []*ast.FuncDecl
+	for _, use := range r.useIdents[obj] {
+		field, _ := r.parents[use].(*ast.Field)
+		for expr := ast.Node(use); field == nil && expr != nil; {
+			// receivers like *T or T[K, V]
+			switch x := r.parents[expr].(type) {
+			case *ast.StarExpr, *ast.IndexExpr, *ast.IndexListExpr:
+				expr = x
+			case *ast.Field:
+				field = x
+			default:
+				expr = nil
+			}
+		}
+		if field == nil {
+			continue
+		}
+		list, _ := r.parents[field].(*ast.FieldList)
+		if fd, _ := r.parents[list].(*ast.FuncDecl); fd != nil && fd.Recv == list {
+			methods = append(methods, fd)
+		}
+	}
+	return methods
+}
+
+// removeTypeSpec tries to remove a type which isn't used anywhere, along
+// with its methods.
+func (r *reducer) removeTypeSpec(ts *ast.TypeSpec) bool {
+	if !r.removableName(ts.Name) {
+		return false
+	}
+	nodes := []ast.Node{ts}
+	var decls []ast.Decl
+	for _, fd := range r.typeMethods(ts) {
+		if r.parents[fd] != r.file || !r.removableName(fd.Name) {
+			return false
+		}
+		nodes = append(nodes, fd)
+		decls = append(decls, fd)
+	}
+	if r.usedOutside(nodes...) {
+		return false
+	}
+	gd := r.parents[ts].(*ast.GenDecl)
+	var undos []func()
+	grouped := r.parents[gd] != r.file || len(gd.Specs) > 1
+	if grouped {
+		undos = append(undos, r.removeSpec(ts))
+	} else {
+		decls = append(decls, gd)
+	}
+	if len(decls) > 0 {
+		undos = append(undos, r.removeDecls(r.file, decls...))
+	}
+	r.afterDelete(nodes...)
+	if !r.okChange() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+		return false
+	}
+	if grouped {
+		r.mergeLines(ts.Pos(), ts.End()+1)
+	}
+	for _, decl := range decls {
+		r.mergeDeclLines(r.file, decl)
+	}
+	if len(nodes) > 1 {
+		r.logChange(ts, "removed type decl and its methods")
+	} else {
+		r.logChange(ts, "removed type decl")
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// execGroup runs programs like interp.DefaultExec, but starts each of
+// them in a process group of its own. Once ctx is done, the entire group
+// is killed, so that no children such as the compiler or a built binary
+// are left running after a timeout.
+func execGroup(ctx context.Context, path string, args []string) error {
+	mc, _ := interp.FromModuleContext(ctx)
+	if path == "" {
+		fmt.Fprintf(mc.Stderr, "%q: executable file not found in $PATH\n", args[0])
+		return interp.ExitStatus(127)
+	}
+	cmd := exec.Cmd{
+		Path:   path,
+		Args:   args,
+		Env:    execEnv(mc.Env),
+		Dir:    mc.Dir,
+		Stdin:  mc.Stdin,
+		Stdout: mc.Stdout,
+		Stderr: mc.Stderr,
+	}
+	setProcGroup(&cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(mc.Stderr, "%v\n", err)
+		return interp.ExitStatus(127)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcGroup(&cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if x, ok := err.(*exec.ExitError); ok {
+		// started, but errored - default to 1 if the OS
+		// doesn't have exit statuses
+		if status, ok := x.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				// like shells do
+				return interp.ExitStatus(128 + int(status.Signal()))
+			}
+			return interp.ExitStatus(status.ExitStatus())
+		}
+		return interp.ExitStatus(1)
+	}
+	return err
+}
+
+// buildOnly reports whether a command only builds Go code without running
+// it, like go build and go vet. -timeout doesn't apply to those, as how
+// long they take depends on the machine rather than on the code.
+func buildOnly(args []string) bool {
+	if len(args) < 2 || args[0] != "go" {
+		return false
+	}
+	switch args[1] {
+	case "build", "install", "vet":
+		return true
+	}
+	return false
+}
+
+// execEnv returns the exported variables in env, as a list of key=value
+// pairs.
+func execEnv(env expand.Environ) []string {
+	var list []string
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported {
+			list = append(list, name+"="+vr.String())
+		}
+		return true
+	})
+	return list
+}
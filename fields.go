@@ -0,0 +1,247 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// reduceField tries to remove or simplify a struct field or an interface
+// method, reporting whether it changed anything.
+func (r *reducer) reduceField(field *ast.Field) bool {
+	list, _ := r.parents[field].(*ast.FieldList)
+	switch r.parents[list].(type) {
+	case *ast.StructType:
+		if r.removeStructField(list, field) {
+			return true
+		}
+		return r.simplifyFieldType(field)
+	case *ast.InterfaceType:
+		return r.removeIfaceMethod(list, field)
+	}
+	return false
+}
+
+// embeddedIdent returns the identifier naming an embedded field, such as
+// T in *pkg.T.
+func embeddedIdent(x ast.Expr) *ast.Ident {
+	for {
+		switch y := x.(type) {
+		case *ast.Ident:
+			return y
+		case *ast.StarExpr:
+			x = y.X
+		case *ast.SelectorExpr:
+			x = y.Sel
+		case *ast.IndexExpr:
+			x = y.X
+		case *ast.IndexListExpr:
+			x = y.X
+		default:
+			return nil
+		}
+	}
+}
+
+// removeFromList removes a field from a field list.
+func removeFromList(list *ast.FieldList, field *ast.Field) (undo func()) {
+	orig := list.List
+	for i, field2 := range orig {
+		if field2 == field {
+			list.List = append(orig[:i:i], orig[i+1:]...)
+			break
+		}
+	}
+	return func() { list.List = orig }
+}
+
+// removeStructField tries to remove a struct field, or one of its names
+// if it declares many, along with its uses where possible.
+func (r *reducer) removeStructField(list *ast.FieldList, field *ast.Field) bool {
+	names := field.Names
+	if len(names) == 0 { // embedded
+		id := embeddedIdent(field.Type)
+		if id == nil || !r.removableName(id) {
+			return false
+		}
+		undo := removeFromList(list, field)
+		if r.removedField(id, field) {
+			r.logChange(field, "removed embedded field")
+			return true
+		}
+		undo()
+		return false
+	}
+	for i, name := range names {
+		if !r.removableName(name) {
+			continue
+		}
+		var undo func()
+		if len(names) == 1 {
+			undo = removeFromList(list, field)
+		} else {
+			field.Names = append(names[:i:i], names[i+1:]...)
+			undo = func() { field.Names = names }
+		}
+		if r.removedField(name, field) {
+			r.logChange(name, "removed struct field")
+			return true
+		}
+		undo()
+	}
+	return false
+}
+
+// removedField removes the uses of a struct field which was just removed
+// from its struct type, and reports whether the change worked.
+func (r *reducer) removedField(id *ast.Ident, field *ast.Field) bool {
+	removed := []ast.Node{field.Type}
+	var undos []func()
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	stmts := make(map[ast.Stmt]bool)
+	for _, use := range r.useIdents[r.info.Defs[id]] {
+		switch x := r.parents[use].(type) {
+		case *ast.KeyValueExpr: // T{f: v}
+			cl, _ := r.parents[x].(*ast.CompositeLit)
+			if cl == nil || x.Key != use {
+				undoAll()
+				return false
+			}
+			orig := cl.Elts
+			for i, elt := range orig {
+				if elt == x {
+					cl.Elts = append(orig[:i:i], orig[i+1:]...)
+					break
+				}
+			}
+			undos = append(undos, func() { cl.Elts = orig })
+			removed = append(removed, x)
+		case *ast.SelectorExpr: // x.f = v
+			stmt := r.fieldUseStmt(x)
+			if stmt == nil || r.parentStmts(stmt) == nil {
+				undoAll()
+				return false
+			}
+			if stmts[stmt] {
+				continue
+			}
+			stmts[stmt] = true
+			undos = append(undos, r.replaceStmts(stmt, nil))
+			removed = append(removed, stmt)
+		default:
+			undoAll()
+			return false
+		}
+	}
+	r.afterDelete(removed...)
+	if r.okChange() {
+		return true
+	}
+	undoAll()
+	return false
+}
+
+// fieldUseStmt returns the statement that only assigns to or calls a
+// selector, or nil if the statement does anything else with it.
+func (r *reducer) fieldUseStmt(sel *ast.SelectorExpr) ast.Stmt {
+	switch x := r.parents[sel].(type) {
+	case *ast.AssignStmt:
+		if x.Tok != token.DEFINE && len(x.Lhs) == 1 && x.Lhs[0] == sel {
+			return x
+		}
+	case *ast.IncDecStmt:
+		return x
+	case *ast.CallExpr: // x.f()
+		if es, _ := r.parents[x].(*ast.ExprStmt); es != nil && x.Fun == sel {
+			return es
+		}
+	}
+	return nil
+}
+
+// simplifyFieldType tries to replace the type of a struct field by int.
+// Fields of basic types are left alone, as they are simple enough.
+func (r *reducer) simplifyFieldType(field *ast.Field) bool {
+	if len(field.Names) == 0 {
+		return false
+	}
+	obj := r.info.Defs[field.Names[0]]
+	if obj == nil {
+		return false
+	}
+	if _, ok := obj.Type().(*types.Basic); ok {
+		return false
+	}
+	orig := field.Type
+	field.Type = &ast.Ident{NamePos: orig.Pos(), Name: "int"}
+	r.afterDelete(orig)
+	if r.okChange() {
+		r.mergeLines(orig.Pos(), orig.End())
+		r.parents[field.Type] = field
+		r.logChange(orig, "field type -> int")
+		return true
+	}
+	field.Type = orig
+	return false
+}
+
+// removeIfaceMethod tries to remove a method or an embedded interface
+// from an interface type. Methods which were only declared to implement
+// the interface method are removed along with it.
+func (r *reducer) removeIfaceMethod(list *ast.FieldList, field *ast.Field) bool {
+	if len(field.Names) == 0 { // embedded
+		undo := removeFromList(list, field)
+		r.afterDelete(field.Type)
+		if r.okChange() {
+			r.logChange(field, "removed embedded interface")
+			return true
+		}
+		undo()
+		return false
+	}
+	name := field.Names[0]
+	if !r.removableName(name) || len(r.useIdents[r.info.Defs[name]]) > 0 {
+		return false
+	}
+	var impls []ast.Decl
+	for _, decl := range r.file.Decls {
+		fd, _ := decl.(*ast.FuncDecl)
+		if fd == nil || fd.Recv == nil || fd.Name.Name != name.Name {
+			continue
+		}
+		if !r.usedOutside(fd) {
+			impls = append(impls, fd)
+		}
+	}
+	undo := removeFromList(list, field)
+	if len(impls) > 0 {
+		undoImpls := r.removeDecls(r.file, impls...)
+		nodes := []ast.Node{field}
+		for _, decl := range impls {
+			nodes = append(nodes, decl)
+		}
+		r.afterDelete(nodes...)
+		if r.okChange() {
+			for _, decl := range impls {
+				r.mergeDeclLines(r.file, decl)
+			}
+			r.logChange(field, "removed interface method and its implementations")
+			return true
+		}
+		undoImpls()
+	}
+	r.afterDelete(field)
+	if r.okChange() {
+		r.logChange(field, "removed interface method")
+		return true
+	}
+	undo()
+	return false
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// flattenImport tries to move all the declarations of the local package
+// imported by imp into the importing file, so that the import can be
+// dropped. Declarations are renamed where their names would collide.
+//
+// Only packages imported by a single file are flattened, so that they
+// are no longer needed at all afterwards.
+func (r *reducer) flattenImport(imp *ast.ImportSpec) bool {
+	if imp.Name != nil && (imp.Name.Name == "_" || imp.Name.Name == ".") {
+		return false
+	}
+	path, _ := strconv.Unquote(imp.Path.Value)
+	var dep *localPkg
+	for _, lp := range r.pkgs {
+		if lp.path == path {
+			dep = lp
+		}
+	}
+	lp := r.filePkg(r.file)
+	if dep == nil || dep == lp || dep.types == nil || lp.types == nil {
+		return false
+	}
+	for _, lp2 := range r.pkgs {
+		for _, f := range lp2.files {
+			for _, imp2 := range fileImports(f) {
+				path2, _ := strconv.Unquote(imp2.Path.Value)
+				if path2 == path && imp2 != imp {
+					return false
+				}
+			}
+		}
+	}
+	var pkgName *types.PkgName
+	if imp.Name != nil {
+		pkgName, _ = r.info.Defs[imp.Name].(*types.PkgName)
+	} else {
+		pkgName, _ = r.info.Implicits[imp].(*types.PkgName)
+	}
+	if pkgName == nil {
+		return false
+	}
+	scope := lp.types.Scope()
+
+	// names declared at the file scope, which are the imports; those
+	// in any of the package's files can't be declared in the package
+	fileNames := make(map[string]string)
+	otherNames := make(map[string]bool)
+	for _, f := range lp.files {
+		for _, imp2 := range fileImports(f) {
+			name := r.importName(imp2)
+			if name == "" {
+				return false
+			}
+			if f != r.file {
+				otherNames[name] = true
+				continue
+			}
+			path2, _ := strconv.Unquote(imp2.Path.Value)
+			fileNames[name] = path2
+		}
+	}
+	var newImps []ast.Spec
+	var depDecls []ast.Decl
+	for _, f := range dep.files {
+		for _, decl := range f.Decls {
+			if gd, _ := decl.(*ast.GenDecl); gd == nil || gd.Tok != token.IMPORT {
+				depDecls = append(depDecls, decl)
+			}
+		}
+		for _, imp2 := range fileImports(f) {
+			name := r.importName(imp2)
+			if name == "" || name == "." || name == "C" {
+				return false
+			}
+			path2, _ := strconv.Unquote(imp2.Path.Value)
+			if name == "_" {
+				newImps = append(newImps, imp2)
+				continue
+			}
+			if p, e := fileNames[name]; e {
+				if p != path2 {
+					return false
+				}
+				continue
+			}
+			if scope.Lookup(name) != nil {
+				return false
+			}
+			fileNames[name] = path2
+			newImps = append(newImps, imp2)
+		}
+	}
+	// the imported package's name is going away
+	delete(fileNames, pkgName.Name())
+
+	// uses of builtins in dep must not be shadowed
+	for _, f := range dep.files {
+		shadowed := false
+		ast.Inspect(f, func(node ast.Node) bool {
+			id, _ := node.(*ast.Ident)
+			if obj := r.info.Uses[id]; obj != nil && obj.Parent() == types.Universe {
+				if scope.Lookup(id.Name) != nil {
+					shadowed = true
+				}
+			}
+			return !shadowed
+		})
+		if shadowed {
+			return false
+		}
+	}
+
+	// pick the new names for dep's declarations
+	depScope := dep.types.Scope()
+	newNames := make(map[types.Object]string)
+	taken := make(map[string]bool)
+	for _, name := range depScope.Names() {
+		obj := depScope.Lookup(name)
+		newName := name
+		for scope.Lookup(newName) != nil ||
+			types.Universe.Lookup(newName) != nil ||
+			fileNames[newName] != "" || otherNames[newName] || taken[newName] ||
+			(newName != name && depScope.Lookup(newName) != nil) {
+			newName += "_"
+		}
+		taken[newName] = true
+		for _, use := range r.useIdents[obj] {
+			useScope := depScope.Innermost(use.Pos())
+			if r.fset.File(use.Pos()) == r.fset.File(r.file.Pos()) {
+				useScope = scope.Innermost(use.Pos())
+			}
+			if useScope == nil {
+				continue
+			}
+			_, found := useScope.LookupParent(newName, use.Pos())
+			if found != nil && found != obj {
+				return false
+			}
+		}
+		if newName != name {
+			newNames[obj] = newName
+		}
+	}
+
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	rename := func(id *ast.Ident, name string) {
+		old := id.Name
+		id.Name = name
+		undos = append(undos, func() { id.Name = old })
+	}
+	for obj, newName := range newNames {
+		rename(r.revDefs[obj], newName)
+		for _, use := range r.useIdents[obj] {
+			rename(use, newName)
+		}
+	}
+	var sels []*ast.SelectorExpr
+	ast.Inspect(r.file, func(node ast.Node) bool {
+		sel, _ := node.(*ast.SelectorExpr)
+		if sel == nil {
+			return true
+		}
+		if id, _ := sel.X.(*ast.Ident); id != nil && r.info.Uses[id] == pkgName {
+			sels = append(sels, sel)
+		}
+		return true
+	})
+	for _, sel := range sels {
+		ref := r.exprRef(sel)
+		if ref == nil {
+			undo()
+			return false
+		}
+		*ref = sel.Sel
+		sel := sel
+		undos = append(undos, func() { *ref = sel })
+	}
+	undos = append(undos, r.removeSpec(imp))
+	oldDecls := r.file.Decls
+	var decls []ast.Decl
+	var impDecl *ast.GenDecl
+	if len(newImps) > 0 {
+		impDecl = &ast.GenDecl{
+			TokPos: imp.Pos(),
+			Tok:    token.IMPORT,
+			Lparen: imp.Pos(),
+			Specs:  newImps,
+			Rparen: imp.End(),
+		}
+		decls = append(decls, impDecl)
+	}
+	decls = append(decls, r.file.Decls...)
+	r.file.Decls = append(decls, depDecls...)
+	undos = append(undos, func() { r.file.Decls = oldDecls })
+	if !r.okChange() {
+		undo()
+		return false
+	}
+	for _, sel := range sels {
+		r.parents[sel.Sel] = r.parents[sel]
+	}
+	if impDecl != nil {
+		r.parents[impDecl] = r.file
+		for _, spec := range newImps {
+			r.parents[spec] = impDecl
+		}
+	}
+	for _, decl := range depDecls {
+		r.parents[decl] = r.file
+	}
+	return true
+}
+
+// importName returns the name an import is known by in its file, or an
+// empty string if it isn't known.
+func (r *reducer) importName(imp *ast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	if obj := r.info.Implicits[imp]; obj != nil {
+		return obj.Name()
+	}
+	return ""
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+)
+
+// typeParamsOwner returns the generic declaration that list declares
+// the type parameters of, which is either an *ast.FuncDecl or an
+// *ast.TypeSpec. It returns nil if list is any other kind of list.
+func (r *reducer) typeParamsOwner(list *ast.FieldList) ast.Node {
+	switch x := r.parents[list].(type) {
+	case *ast.FuncType:
+		if x.TypeParams != list {
+			break
+		}
+		if fd, _ := r.parents[x].(*ast.FuncDecl); fd != nil {
+			return fd
+		}
+	case *ast.TypeSpec:
+		if x.TypeParams == list {
+			return x
+		}
+	}
+	return nil
+}
+
+// instFun returns the generic function or type being instantiated in an
+// expression such as f[T], or nil if it's not an index expression.
+func instFun(x ast.Expr) ast.Expr {
+	switch y := x.(type) {
+	case *ast.IndexExpr:
+		return y.X
+	case *ast.IndexListExpr:
+		return y.X
+	}
+	return nil
+}
+
+// instIdent returns the identifier naming the generic function or type
+// in an instantiation such as f[T] or pkg.T[A, B], or nil if x isn't
+// an instantiation.
+func (r *reducer) instIdent(x ast.Expr) *ast.Ident {
+	var id *ast.Ident
+	if fun := instFun(x); fun != nil {
+		x = fun
+	}
+	switch y := x.(type) {
+	case *ast.Ident:
+		id = y
+	case *ast.SelectorExpr:
+		id = y.Sel
+	}
+	if _, ok := r.info.Instances[id]; !ok {
+		return nil
+	}
+	return id
+}
+
+// typeArgs returns the explicit type arguments of an instantiation,
+// which may be none if they are all inferred.
+func typeArgs(x ast.Expr) []ast.Expr {
+	switch y := x.(type) {
+	case *ast.IndexExpr:
+		return []ast.Expr{y.Index}
+	case *ast.IndexListExpr:
+		return y.Indices
+	}
+	return nil
+}
+
+// withTypeArgs builds an instantiation of fun with args, which is just
+// fun if there are no args left.
+func withTypeArgs(orig ast.Expr, fun ast.Expr, args []ast.Expr) ast.Expr {
+	var lbrack, rbrack = orig.Pos(), orig.End()
+	switch y := orig.(type) {
+	case *ast.IndexExpr:
+		lbrack, rbrack = y.Lbrack, y.Rbrack
+	case *ast.IndexListExpr:
+		lbrack, rbrack = y.Lbrack, y.Rbrack
+	}
+	switch len(args) {
+	case 0:
+		return fun
+	case 1:
+		return &ast.IndexExpr{X: fun, Lbrack: lbrack, Index: args[0], Rbrack: rbrack}
+	}
+	return &ast.IndexListExpr{X: fun, Lbrack: lbrack, Indices: args, Rbrack: rbrack}
+}
+
+// reduceTypeParams tries to simplify the constraint of each type
+// parameter in list to any, and then to remove each of them.
+func (r *reducer) reduceTypeParams(list *ast.FieldList) {
+	owner := r.typeParamsOwner(list)
+	if owner == nil {
+		return
+	}
+	for _, field := range list.List {
+		if id, _ := field.Type.(*ast.Ident); id != nil && id.Name == "any" {
+			continue
+		}
+		orig := field.Type
+		r.afterDelete(orig)
+		if field.Type = anyIdent(orig); r.okChange() {
+			r.parents[field.Type] = field
+			r.logChange(orig, "constraint -> any")
+			return
+		}
+		field.Type = orig
+	}
+	i := 0
+	for _, field := range list.List {
+		for _, name := range field.Names {
+			if r.removedTypeParam(owner, list, field, name, i) {
+				r.logChange(name, "removed type param")
+				return
+			}
+			i++
+		}
+	}
+}
+
+// removedTypeParam tries to remove the type parameter name, which is the
+// i-th one in list. Its uses become any, and it is dropped from the
+// explicit type arguments of every instantiation.
+func (r *reducer) removedTypeParam(owner ast.Node, list *ast.FieldList, field *ast.Field, name *ast.Ident, i int) bool {
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	var added []ast.Expr
+	var parents []ast.Node
+	// uses of the type parameter, or of a receiver's type parameter
+	// when the instantiation is a method's receiver type
+	toAny := func(obj types.Object) bool {
+		for _, use := range r.useIdents[obj] {
+			ref := r.exprRef(use)
+			if ref == nil {
+				return false
+			}
+			id := anyIdent(use)
+			*ref = id
+			use := use
+			undos = append(undos, func() { *ref = use })
+			r.parents[id] = r.parents[use]
+		}
+		return true
+	}
+	if !toAny(r.info.Defs[name]) {
+		undo()
+		return false
+	}
+
+	oldNames, oldList := field.Names, list.List
+	for j, name2 := range field.Names {
+		if name2 == name {
+			field.Names = append(field.Names[:j:j], field.Names[j+1:]...)
+		}
+	}
+	if len(field.Names) == 0 {
+		for j, field2 := range list.List {
+			if field2 == field {
+				list.List = append(list.List[:j:j], list.List[j+1:]...)
+			}
+		}
+	}
+	undos = append(undos, func() { field.Names, list.List = oldNames, oldList })
+	var genObj types.Object
+	switch x := owner.(type) {
+	case *ast.FuncDecl:
+		genObj = r.info.Defs[x.Name]
+		if len(list.List) == 0 {
+			x.Type.TypeParams = nil
+			undos = append(undos, func() { x.Type.TypeParams = list })
+		}
+	case *ast.TypeSpec:
+		genObj = r.info.Defs[x.Name]
+		if len(list.List) == 0 {
+			x.TypeParams = nil
+			undos = append(undos, func() { x.TypeParams = list })
+		}
+	}
+
+	var deleted []ast.Node
+	for _, use := range r.useIdents[genObj] {
+		var fun ast.Expr = use
+		if sel, _ := r.parents[use].(*ast.SelectorExpr); sel != nil && sel.Sel == use {
+			fun = sel
+		}
+		inst, _ := r.parents[fun].(ast.Expr)
+		if inst == nil || instFun(inst) != fun {
+			continue
+		}
+		args := typeArgs(inst)
+		if len(args) <= i {
+			continue
+		}
+		ref := r.exprRef(inst)
+		if ref == nil {
+			undo()
+			return false
+		}
+		arg := args[i]
+		if id, _ := arg.(*ast.Ident); id != nil && r.info.Defs[id] != nil {
+			if !toAny(r.info.Defs[id]) {
+				undo()
+				return false
+			}
+		} else {
+			deleted = append(deleted, arg)
+		}
+		newArgs := append(args[:i:i], args[i+1:]...)
+		orig := *ref
+		*ref = withTypeArgs(orig, fun, newArgs)
+		undos = append(undos, func() { *ref = orig })
+		added = append(added, *ref)
+		parents = append(parents, r.parents[inst])
+	}
+	r.afterDelete(deleted...)
+	if !r.okChange() {
+		undo()
+		return false
+	}
+	for i, inst := range added {
+		r.setParents(inst, parents[i])
+	}
+	return true
+}
+
+// reduceTypeArgs tries to replace each of the explicit type arguments
+// of an instantiation with int, and then with any.
+func (r *reducer) reduceTypeArgs(inst ast.Expr, args []*ast.Expr) bool {
+	if r.instIdent(inst) == nil {
+		return false
+	}
+	for _, ref := range args {
+		orig := *ref
+		id, _ := orig.(*ast.Ident)
+		if id != nil && (id.Name == "int" || id.Name == "any") {
+			continue
+		}
+		if id != nil && r.info.Defs[id] != nil {
+			continue // a method receiver's type parameter
+		}
+		for _, name := range [...]string{"int", "any"} {
+			r.afterDelete(orig)
+			id := &ast.Ident{NamePos: orig.Pos(), Name: name}
+			if *ref = id; r.okChange() {
+				r.parents[id] = inst
+				r.logChange(orig, "type arg -> %s", name)
+				return true
+			}
+		}
+		*ref = orig
+	}
+	return false
+}
+
+// instantiateCall tries to replace a call to a generic function with a
+// call to a copy of the function with its type parameters replaced by
+// the call's type arguments. The copy is added right after the generic
+// function.
+func (r *reducer) instantiateCall(ce *ast.CallExpr) bool {
+	id := r.instIdent(ce.Fun)
+	if id == nil || id != ce.Fun && typeArgs(ce.Fun) == nil {
+		return false
+	}
+	fn, _ := r.info.Uses[id].(*types.Func)
+	if fn == nil || !r.isLocal(fn.Pkg()) {
+		return false
+	}
+	fd, _ := r.parents[r.revDefs[fn]].(*ast.FuncDecl)
+	if fd == nil || fd.Recv != nil || fd.Body == nil || fd.Type.TypeParams == nil {
+		return false
+	}
+	if r.filePkg(r.file).types != fn.Pkg() {
+		return false
+	}
+	file, _ := r.parents[fd].(*ast.File)
+	if file == nil {
+		return false
+	}
+	inst := r.info.Instances[id]
+	args := typeArgs(ce.Fun)
+	if len(args) < inst.TypeArgs.Len() {
+		// some were inferred; spell them out
+		args = nil
+		qualifier := func(pkg *types.Package) string {
+			if pkg == fn.Pkg() {
+				return ""
+			}
+			return pkg.Name()
+		}
+		for i := 0; i < inst.TypeArgs.Len(); i++ {
+			s := types.TypeString(inst.TypeArgs.At(i), qualifier)
+			arg, err := parser.ParseExpr(s)
+			if err != nil {
+				return false
+			}
+			args = append(args, arg)
+		}
+	}
+
+	// build the copy by substituting the type parameters in place
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	i := 0
+	for _, field := range fd.Type.TypeParams.List {
+		for _, name := range field.Names {
+			for _, use := range r.useIdents[r.info.Defs[name]] {
+				ref := r.exprRef(use)
+				if ref == nil {
+					undo()
+					return false
+				}
+				*ref = copyNode(args[i], use.Pos()).(ast.Expr)
+				use := use
+				undos = append(undos, func() { *ref = use })
+			}
+			i++
+		}
+	}
+	tparams := fd.Type.TypeParams
+	fd.Type.TypeParams = nil
+	undos = append(undos, func() { fd.Type.TypeParams = tparams })
+	newFd := copyNode(fd, token.NoPos).(*ast.FuncDecl)
+	undo()
+	newFd.Doc = nil
+	newName := fd.Name.Name + "_"
+	for fn.Pkg().Scope().Lookup(newName) != nil {
+		newName += "_"
+	}
+	newFd.Name.Name = newName
+
+	oldDecls := file.Decls
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		decls = append(decls, decl)
+		if decl == fd {
+			decls = append(decls, newFd)
+		}
+	}
+	file.Decls = decls
+	oldFun := ce.Fun
+	r.afterDelete(oldFun)
+	ce.Fun = &ast.Ident{NamePos: oldFun.Pos(), Name: newName}
+	if file != r.file {
+		r.otherFiles = []*ast.File{file}
+	}
+	ok := r.okChange()
+	r.otherFiles = nil
+	if !ok {
+		file.Decls = oldDecls
+		ce.Fun = oldFun
+		return false
+	}
+	r.parents[ce.Fun] = ce
+	r.setParents(newFd, file)
+	return true
+}
+
+// anyIdent returns a new any identifier, in place of the node orig.
+func anyIdent(orig ast.Node) *ast.Ident {
+	return &ast.Ident{NamePos: orig.Pos(), Name: "any"}
+}
+
+var (
+	objectType = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+	posType    = reflect.TypeOf(token.NoPos)
+)
+
+// copyNode returns a deep copy of an AST node. If pos is valid, it
+// replaces all the valid positions in the copy, which is then meant to
+// be placed at pos.
+func copyNode(node ast.Node, pos token.Pos) ast.Node {
+	return copyValue(reflect.ValueOf(node), pos).Interface().(ast.Node)
+}
+
+func copyValue(v reflect.Value, pos token.Pos) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == objectType || v.Type() == scopeType {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem(), pos))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			c.Field(i).Set(copyValue(v.Field(i), pos))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), pos))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem(), pos))
+		return c
+	}
+	if v.Type() == posType && pos.IsValid() && token.Pos(v.Int()).IsValid() {
+		// missing positions, like those of optional parentheses,
+		// must stay missing
+		return reflect.ValueOf(pos)
+	}
+	return v
+}
@@ -1,5 +1,7 @@
 module mvdan.cc/goreduce
 
+go 1.22
+
 require (
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2 // indirect
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
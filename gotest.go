@@ -0,0 +1,214 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isTestFunc reports whether a declaration is a test, benchmark, example
+// or fuzz function run by go test. TestMain is not included.
+func isTestFunc(decl ast.Decl) bool {
+	fd, _ := decl.(*ast.FuncDecl)
+	if fd == nil || fd.Recv != nil || fd.Name.Name == "TestMain" {
+		return false
+	}
+	name := fd.Name.Name
+	for _, prefix := range [...]string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(name) == len(prefix) {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+		return !unicode.IsLower(r)
+	}
+	return false
+}
+
+// removeOtherTests tries to remove all the test functions in the
+// current file other than the test being reduced, all at once. If that
+// doesn't work, as a test left might use some of the others, chunks of
+// them are tried down to single ones. With -test=TestFoo/sub, TestFoo is
+// the test being reduced.
+func (r *reducer) removeOtherTests() {
+	name := strings.SplitN(*testName, "/", 2)[0]
+	var others []ast.Decl
+	for _, decl := range r.file.Decls {
+		if isTestFunc(decl) && decl.(*ast.FuncDecl).Name.Name != name {
+			others = append(others, decl)
+		}
+	}
+	if len(others) == 0 {
+		return
+	}
+	try := func(decls []ast.Decl) bool {
+		removed := make([]ast.Node, len(decls))
+		for i, decl := range decls {
+			removed[i] = decl
+		}
+		if r.usedOutside(removed...) {
+			return false
+		}
+		undo := r.removeDecls(r.file, decls...)
+		r.afterDelete(removed...)
+		if r.okChange() {
+			return true
+		}
+		undo()
+		return false
+	}
+	if try(others) {
+		r.logChange(others[0], "other tests removed")
+		return
+	}
+	chunks(len(others), 1, func(start, end int) bool {
+		if !try(others[start:end]) {
+			return false
+		}
+		if end-start == 1 {
+			r.logChange(others[start], "other test removed")
+		} else {
+			r.logChange(others[start], "%d other tests removed", end-start)
+		}
+		return true
+	})
+}
+
+// inlineTestPkg tries to turn the external test package into part of
+// the package it tests, dropping its imports of the latter. It is done
+// once for all of its files, when walking the first of them.
+func (r *reducer) inlineTestPkg() bool {
+	xtest, main := r.filePkg(r.file), r.mainPkg()
+	if xtest == main || xtest.dir != main.dir || r.file != xtest.files[0] {
+		return false
+	}
+	if xtest.types == nil || main.types == nil {
+		return false
+	}
+	scope, xscope := main.types.Scope(), xtest.types.Scope()
+	for _, name := range xscope.Names() {
+		if scope.Lookup(name) != nil {
+			return false
+		}
+	}
+	for _, f := range main.files {
+		for _, imp := range fileImports(f) {
+			if xscope.Lookup(r.importName(imp)) != nil {
+				return false
+			}
+		}
+	}
+	var imps []*ast.ImportSpec
+	pkgNames := make(map[types.Object]bool)
+	for _, f := range xtest.files {
+		for _, imp := range fileImports(f) {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := r.importName(imp)
+			if path != main.path {
+				if scope.Lookup(name) != nil {
+					return false
+				}
+				continue
+			}
+			imps = append(imps, imp)
+			if imp.Name != nil {
+				pkgNames[r.info.Defs[imp.Name]] = true
+			} else {
+				pkgNames[r.info.Implicits[imp]] = true
+			}
+		}
+		shadowed := false
+		ast.Inspect(f, func(node ast.Node) bool {
+			id, _ := node.(*ast.Ident)
+			if obj := r.info.Uses[id]; obj != nil && obj.Parent() == types.Universe {
+				if scope.Lookup(id.Name) != nil {
+					shadowed = true
+				}
+			}
+			return !shadowed
+		})
+		if shadowed {
+			return false
+		}
+	}
+
+	var sels []*ast.SelectorExpr
+	for _, f := range xtest.files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			sel, _ := node.(*ast.SelectorExpr)
+			if sel == nil {
+				return true
+			}
+			if id, _ := sel.X.(*ast.Ident); id != nil && pkgNames[r.info.Uses[id]] {
+				sels = append(sels, sel)
+			}
+			return true
+		})
+	}
+	var undos []func()
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	for _, sel := range sels {
+		useScope := xscope.Innermost(sel.Pos())
+		if useScope != nil {
+			if _, found := useScope.LookupParent(sel.Sel.Name, sel.Pos()); found != nil {
+				undo()
+				return false
+			}
+		}
+		ref := r.exprRef(sel)
+		if ref == nil {
+			undo()
+			return false
+		}
+		*ref = sel.Sel
+		sel := sel
+		undos = append(undos, func() { *ref = sel })
+	}
+	for _, imp := range imps {
+		undos = append(undos, r.removeSpec(imp))
+	}
+	for _, f := range xtest.files {
+		id, name := f.Name, f.Name.Name
+		id.Name = main.pkg.Name
+		undos = append(undos, func() { id.Name = name })
+	}
+	r.otherFiles = xtest.files[1:]
+	ok := r.okChange()
+	r.otherFiles = nil
+	if !ok {
+		undo()
+		return false
+	}
+	for _, sel := range sels {
+		r.parents[sel.Sel] = r.parents[sel]
+	}
+	for name, f := range xtest.pkg.Files {
+		main.pkg.Files[name] = f
+	}
+	main.files = append(main.files, xtest.files...)
+	sort.Slice(main.files, func(i, j int) bool {
+		return r.fset.Position(main.files[i].Pos()).Filename <
+			r.fset.Position(main.files[j].Pos()).Filename
+	})
+	pkgs := r.pkgs[:0:0]
+	for _, lp := range r.pkgs {
+		if lp != xtest {
+			pkgs = append(pkgs, lp)
+		}
+	}
+	r.pkgs = pkgs
+	return true
+}
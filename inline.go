@@ -0,0 +1,300 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// inlinable returns the type and body of the func called by ce, as long
+// as it's a local func that can be inlined at the call site: it isn't
+// generic nor recursive, it's not variadic, all of its arguments are
+// given one by one, and the names used in its body mean the same at the
+// call site.
+func (r *reducer) inlinable(ce *ast.CallExpr) (*ast.FuncType, *ast.BlockStmt) {
+	if ce.Ellipsis.IsValid() || r.instIdent(ce.Fun) != nil {
+		return nil, nil
+	}
+	ftype, fbody := r.funcDetails(ce.Fun)
+	if fbody == nil || ftype.TypeParams != nil {
+		return nil, nil
+	}
+	params := ftype.Params.List
+	if len(params) > 0 {
+		if _, ok := params[len(params)-1].Type.(*ast.Ellipsis); ok {
+			return nil, nil
+		}
+	}
+	sign, _ := r.info.TypeOf(ce.Fun).(*types.Signature)
+	if sign == nil || sign.Params().Len() != len(ce.Args) {
+		return nil, nil // f(g()) with many results
+	}
+	if id, _ := ce.Fun.(*ast.Ident); id != nil {
+		for _, use := range r.useIdents[r.info.Uses[id]] {
+			if use.Pos() > fbody.Pos() && use.End() < fbody.End() {
+				return nil, nil
+			}
+		}
+	}
+	if !r.sameNames(ftype, fbody, ce.Pos()) {
+		return nil, nil
+	}
+	return ftype, fbody
+}
+
+// sameNames reports whether the names used within a func body, but
+// declared outside of the func, refer to the same objects at pos.
+func (r *reducer) sameNames(ftype *ast.FuncType, body *ast.BlockStmt, pos token.Pos) bool {
+	scope := r.filePkg(r.file).types.Scope().Innermost(pos)
+	if scope == nil {
+		return false
+	}
+	same := true
+	ast.Inspect(body, func(node ast.Node) bool {
+		id, _ := node.(*ast.Ident)
+		obj := r.info.Uses[id]
+		if obj == nil || !same {
+			return same
+		}
+		if obj.Pos() >= ftype.Pos() && obj.Pos() < body.End() {
+			return true // declared within
+		}
+		switch obj.(type) {
+		case *types.PkgName:
+			return true // imports are per file
+		}
+		if obj.Parent() == nil {
+			return true // field, method or label
+		}
+		if _, found := scope.LookupParent(id.Name, pos); found != obj {
+			same = false
+		}
+		return true
+	})
+	return same
+}
+
+// paramNames returns the names of the parameters of a func, using the
+// blank identifier for the unnamed ones.
+func paramNames(ftype *ast.FuncType) []string {
+	var names []string
+	for _, field := range ftype.Params.List {
+		if len(field.Names) == 0 {
+			names = append(names, "_")
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// paramBindings returns the declarations binding the arguments of a
+// call to its parameters, as in var a, b T = x, y. Parameters which the
+// body doesn't use are bound to the blank identifier, as the variables
+// would be declared and not used otherwise. It returns false if an
+// argument uses the name of a parameter declared before it, as that
+// would refer to the new variable instead.
+func (r *reducer) paramBindings(ftype *ast.FuncType, args []ast.Expr, pos token.Pos) ([]ast.Stmt, bool) {
+	names := paramNames(ftype)
+	i := 0
+	for _, field := range ftype.Params.List {
+		for _, name := range field.Names {
+			if obj := r.info.Defs[name]; obj != nil && len(r.useIdents[obj]) == 0 {
+				names[i] = "_"
+			}
+			i++
+		}
+		if len(field.Names) == 0 {
+			i++
+		}
+	}
+	var stmts []ast.Stmt
+	i = 0
+	for _, field := range ftype.Params.List {
+		spec := &ast.ValueSpec{Type: copyNode(field.Type, pos).(ast.Expr)}
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for j := 0; j < n; j++ {
+			for _, name := range names[:i] {
+				if name != "_" && mentions(args[i], name) {
+					return nil, false
+				}
+			}
+			spec.Names = append(spec.Names, &ast.Ident{NamePos: pos, Name: names[i]})
+			spec.Values = append(spec.Values, copyNode(args[i], pos).(ast.Expr))
+			i++
+		}
+		stmts = append(stmts, &ast.DeclStmt{Decl: &ast.GenDecl{
+			TokPos: pos,
+			Tok:    token.VAR,
+			Specs:  []ast.Spec{spec},
+		}})
+	}
+	return stmts, true
+}
+
+// mentions reports whether an identifier with a name appears in node.
+func mentions(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		if id, _ := node.(*ast.Ident); id != nil && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// inlineCallStmt tries to replace a call statement by a block with the
+// body of the func, binding the arguments to its parameters.
+func (r *reducer) inlineCallStmt(es *ast.ExprStmt, ce *ast.CallExpr) bool {
+	ftype, fbody := r.inlinable(ce)
+	if fbody == nil || anyFuncControlNodes(fbody) {
+		return false
+	}
+	if len(ftype.Params.List) == 0 && (ftype.Results == nil || len(ftype.Results.List) == 0) {
+		return false // covered by the simpler "inlined call" rule
+	}
+	stmts, ok := r.paramBindings(ftype, ce.Args, ce.Pos())
+	if !ok {
+		return false
+	}
+	body := copyNode(fbody, ce.Pos()).(*ast.BlockStmt)
+	block := &ast.BlockStmt{
+		Lbrace: es.Pos(),
+		List:   append(stmts, body.List...),
+		Rbrace: es.End(),
+	}
+	ref := r.stmtRef(es)
+	if ref == nil {
+		return false
+	}
+	*ref = block
+	r.afterDelete(ce.Fun)
+	if !r.okChange() {
+		*ref = es
+		return false
+	}
+	r.setParents(block, r.parents[es])
+	return true
+}
+
+// inlineCallExpr tries to replace a call by the expression that the func
+// returns, when its body is a single return statement. The arguments are
+// put in place of the parameters.
+func (r *reducer) inlineCallExpr(ce *ast.CallExpr) bool {
+	ftype, fbody := r.inlinable(ce)
+	if fbody == nil || len(fbody.List) != 1 {
+		return false
+	}
+	ret, _ := fbody.List[0].(*ast.ReturnStmt)
+	if ret == nil || len(ret.Results) != 1 {
+		return false
+	}
+	params := make(map[types.Object]ast.Expr)
+	i := 0
+	for _, field := range ftype.Params.List {
+		for _, name := range field.Names {
+			params[r.info.Defs[name]] = ce.Args[i]
+			i++
+		}
+		if len(field.Names) == 0 {
+			i++
+		}
+	}
+	// put the arguments in place temporarily, to copy the result
+	var undos []func()
+	used := make(map[ast.Expr]bool)
+	ok := true
+	ast.Inspect(ret, func(node ast.Node) bool {
+		id, _ := node.(*ast.Ident)
+		arg := params[r.info.Uses[id]]
+		if arg == nil {
+			return true
+		}
+		ref := r.exprRef(id)
+		if ref == nil {
+			ok = false
+			return false
+		}
+		switch arg.(type) {
+		case *ast.Ident, *ast.BasicLit, *ast.CallExpr, *ast.SelectorExpr,
+			*ast.IndexExpr, *ast.CompositeLit, *ast.ParenExpr:
+			*ref = arg
+		default:
+			*ref = &ast.ParenExpr{Lparen: arg.Pos(), X: arg, Rparen: arg.End()}
+		}
+		undos = append(undos, func() { *ref = id })
+		used[arg] = true
+		return true
+	})
+	var expr ast.Expr
+	if ok {
+		expr = copyNode(ret.Results[0], ce.Pos()).(ast.Expr)
+	}
+	for _, undo := range undos {
+		undo()
+	}
+	if !ok {
+		return false
+	}
+	deleted := []ast.Node{ce.Fun}
+	for _, arg := range ce.Args {
+		if !used[arg] {
+			deleted = append(deleted, arg)
+		}
+	}
+	r.afterDelete(deleted...)
+	if !r.changedExpr(ce, expr) {
+		return false
+	}
+	r.setParents(expr, r.parents[ce])
+	return true
+}
+
+// callToClosure tries to replace the func in its only call by a func
+// literal with its body, so that the func itself may be removed. This is
+// useful when the body has early returns, which can't be inlined
+// otherwise.
+func (r *reducer) callToClosure(ce *ast.CallExpr) bool {
+	id, _ := ce.Fun.(*ast.Ident)
+	if id == nil || len(r.useIdents[r.info.Uses[id]]) != 1 {
+		return false
+	}
+	ftype, fbody := r.inlinable(ce)
+	if fbody == nil || !earlyReturns(fbody) {
+		return false
+	}
+	ftype = copyNode(ftype, ce.Pos()).(*ast.FuncType)
+	ftype.Func = ce.Pos()
+	lit := &ast.FuncLit{
+		Type: ftype,
+		Body: copyNode(fbody, ce.Pos()).(*ast.BlockStmt),
+	}
+	fun := ce.Fun
+	ce.Fun = lit
+	r.afterDelete(fun)
+	if !r.okChange() {
+		ce.Fun = fun
+		return false
+	}
+	r.setParents(lit, ce)
+	return true
+}
+
+// earlyReturns reports whether a func body returns anywhere other than
+// at its very end.
+func earlyReturns(body *ast.BlockStmt) bool {
+	for _, ret := range funcReturns(body) {
+		if len(body.List) == 0 || ret != body.List[len(body.List)-1] {
+			return true
+		}
+	}
+	return false
+}
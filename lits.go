@@ -0,0 +1,194 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// shortLit returns a literal as shown in the log, cut short if it's long.
+func shortLit(s string) string {
+	if len(s) <= 10 {
+		return s
+	}
+	switch s[0] {
+	case '"', '`', '\'':
+		return s[:7] + "..." + s[:1]
+	}
+	return s[:7] + "..."
+}
+
+// reduceLit tries to replace a basic literal by a simpler one of the same
+// kind, such as a shorter string or a smaller number.
+func (r *reducer) reduceLit(l *ast.BasicLit) bool {
+	orig := l.Value
+	changeValue := func(val string) bool {
+		if l.Value == val {
+			return false
+		}
+		if l.Value = val; r.okChange() {
+			r.logChange(l, "%s -> %s", shortLit(orig), shortLit(val))
+			return true
+		}
+		l.Value = orig
+		return false
+	}
+	// larger than 1, so that trying 1 or -1 makes it simpler
+	large := func(val constant.Value) bool {
+		return constant.Compare(val, token.GTR, constant.MakeInt64(1))
+	}
+	var vals []string
+	switch l.Kind {
+	case token.STRING:
+		if changeValue(`""`) {
+			return true
+		}
+		return r.shrinkString(l, changeValue)
+	case token.INT:
+		val := constant.MakeFromLiteral(orig, token.INT, 0)
+		if val.Kind() != constant.Int {
+			break
+		}
+		if changeValue("0") {
+			return true
+		}
+		if !large(val) {
+			break
+		}
+		if changeValue("1") || r.negativeOne(l) {
+			return true
+		}
+		half := constant.BinaryOp(val, token.QUO_ASSIGN, constant.MakeInt64(2))
+		vals = append(vals, half.ExactString())
+	case token.FLOAT:
+		val := constant.MakeFromLiteral(orig, token.FLOAT, 0)
+		if val.Kind() != constant.Float || constant.Sign(val) == 0 {
+			break
+		}
+		vals = append(vals, "0.0")
+		if large(val) {
+			vals = append(vals, "1.0")
+		}
+		f, _ := constant.Float64Val(val)
+		if math.IsInf(f, 0) {
+			break
+		}
+		if trunc := math.Trunc(f); trunc != f && f < 1e15 {
+			vals = append(vals, strconv.FormatFloat(trunc, 'f', -1, 64)+".0")
+		}
+		// half as many significant digits
+		mant := strconv.FormatFloat(f, 'e', -1, 64)
+		mant = mant[:strings.IndexByte(mant, 'e')]
+		if digits := len(strings.Replace(mant, ".", "", 1)); digits > 1 {
+			s := strconv.FormatFloat(f, 'g', digits/2, 64)
+			if !strings.ContainsAny(s, ".e") {
+				s += ".0"
+			}
+			if len(s) < len(orig) {
+				vals = append(vals, s)
+			}
+		}
+	case token.IMAG:
+		val := constant.MakeFromLiteral(orig, token.IMAG, 0)
+		if val.Kind() != constant.Complex || constant.Sign(val) == 0 {
+			break
+		}
+		vals = append(vals, "0i")
+		if large(constant.Imag(val)) {
+			vals = append(vals, "1i")
+		}
+	case token.CHAR:
+		vals = append(vals, "'a'")
+	}
+	for _, val := range vals {
+		if changeValue(val) {
+			return true
+		}
+	}
+	return false
+}
+
+// shrinkString tries to remove chunks of bytes from a string literal,
+// starting with its halves, so that only the bytes that matter are kept.
+// Raw strings are kept raw.
+func (r *reducer) shrinkString(l *ast.BasicLit, changeValue func(string) bool) bool {
+	orig := l.Value
+	raw := orig[0] == '`'
+	var s string
+	if raw {
+		s = orig[1 : len(orig)-1]
+	} else {
+		var err error
+		if s, err = strconv.Unquote(orig); err != nil {
+			return false
+		}
+	}
+	return chunks(len(s), 1, func(start, end int) bool {
+		rest := s[:start] + s[end:]
+		if raw {
+			return changeValue("`" + rest + "`")
+		}
+		return changeValue(strconv.Quote(rest))
+	})
+}
+
+// negativeOne tries to replace an integer literal by -1.
+func (r *reducer) negativeOne(l *ast.BasicLit) bool {
+	if _, ok := r.parents[l].(*ast.UnaryExpr); ok {
+		return false // e.g. -5
+	}
+	orig := l.Value
+	neg := &ast.UnaryExpr{
+		OpPos: l.Pos(),
+		Op:    token.SUB,
+		X:     &ast.BasicLit{ValuePos: l.Pos(), Kind: token.INT, Value: "1"},
+	}
+	if !r.changedExpr(l, neg) {
+		return false
+	}
+	r.setParents(neg.X, neg)
+	r.logChange(l, "%s -> -1", shortLit(orig))
+	return true
+}
+
+// removeEltChunks tries to remove chunks of elements from a slice or
+// array literal holding data, such as a long []byte literal, starting
+// with its halves.
+func (r *reducer) removeEltChunks(cl *ast.CompositeLit) bool {
+	t := r.info.TypeOf(cl)
+	if t == nil {
+		return false
+	}
+	switch t.Underlying().(type) {
+	case *types.Slice, *types.Array:
+	default:
+		return false
+	}
+	for _, elt := range cl.Elts {
+		if _, ok := elt.(*ast.BasicLit); !ok {
+			return false
+		}
+	}
+	orig := cl.Elts
+	return chunks(len(orig), 1, func(start, end int) bool {
+		cl.Elts = append(orig[:start:start], orig[end:]...)
+		if r.okChange() {
+			if end < len(orig) {
+				r.mergeLines(orig[start].Pos(), orig[end].Pos())
+			} else {
+				r.mergeLines(orig[start-1].End(), orig[end-1].End())
+			}
+			r.logChange(orig[start], "%d elems removed", end-start)
+			return true
+		}
+		cl.Elts = orig
+		return false
+	})
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// stmtLabel returns the label of a statement, if it has one.
+func (r *reducer) stmtLabel(stmt ast.Stmt) *ast.LabeledStmt {
+	ls, _ := r.parents[stmt].(*ast.LabeledStmt)
+	return ls
+}
+
+// stmtBranches returns the break and continue statements within the body
+// of a loop or select statement which refer to the statement itself. It
+// returns false if a goto jumps to its label, as the statement can't be
+// removed then.
+func (r *reducer) stmtBranches(stmt ast.Stmt, body []ast.Stmt, label *ast.LabeledStmt) ([]*ast.BranchStmt, bool) {
+	var labelObj types.Object
+	if label != nil {
+		labelObj = r.info.Defs[label.Label]
+	}
+	isLoop := false
+	switch stmt.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		isLoop = true
+	}
+	var branches []*ast.BranchStmt
+	var walk func(node ast.Node, inBreak, inContinue bool)
+	walk = func(node ast.Node, inBreak, inContinue bool) {
+		ast.Inspect(node, func(node ast.Node) bool {
+			switch x := node.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ForStmt:
+				walk(x.Body, true, true)
+				return false
+			case *ast.RangeStmt:
+				walk(x.Body, true, true)
+				return false
+			case *ast.SwitchStmt:
+				walk(x.Body, true, inContinue)
+				return false
+			case *ast.TypeSwitchStmt:
+				walk(x.Body, true, inContinue)
+				return false
+			case *ast.SelectStmt:
+				walk(x.Body, true, inContinue)
+				return false
+			case *ast.BranchStmt:
+				if x.Label != nil {
+					if labelObj != nil && r.info.Uses[x.Label] == labelObj {
+						branches = append(branches, x)
+					}
+					break
+				}
+				switch {
+				case x.Tok == token.BREAK && !inBreak,
+					x.Tok == token.CONTINUE && !inContinue:
+					branches = append(branches, x)
+				}
+			}
+			return true
+		})
+	}
+	for _, stmt := range body {
+		walk(stmt, false, !isLoop)
+	}
+	for _, use := range r.useIdents[labelObj] {
+		if bs, _ := r.parents[use].(*ast.BranchStmt); bs == nil || bs.Tok == token.GOTO {
+			return nil, false
+		}
+	}
+	return branches, true
+}
+
+// rangeBindings returns the statement declaring the key and value of a
+// range statement for its first iteration, as in k, v := 0, x[0]. It
+// returns false if they are used and that can't be done.
+func (r *reducer) rangeBindings(rs *ast.RangeStmt) (ast.Stmt, bool) {
+	used := func(expr ast.Expr) bool {
+		id, _ := expr.(*ast.Ident)
+		if id == nil || id.Name == "_" {
+			return expr != nil && id == nil
+		}
+		if obj := r.info.Defs[id]; obj != nil {
+			return len(r.useIdents[obj]) > 0
+		}
+		return false // assigning to an existing variable
+	}
+	keyUsed, valUsed := used(rs.Key), used(rs.Value)
+	if !keyUsed && !valUsed {
+		return nil, true
+	}
+	if rs.Tok != token.DEFINE {
+		return nil, false
+	}
+	pos := rs.Pos()
+	as := &ast.AssignStmt{TokPos: pos, Tok: token.DEFINE}
+	if keyUsed {
+		as.Lhs = append(as.Lhs, rs.Key)
+		as.Rhs = append(as.Rhs, &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: "0"})
+	}
+	t := r.info.TypeOf(rs.X)
+	if t == nil {
+		return nil, false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice, *types.Array:
+	case *types.Pointer: // to an array
+	case *types.Basic:
+		if valUsed || u.Info()&types.IsInteger == 0 {
+			return nil, false // the value would be a rune
+		}
+	default:
+		return nil, false
+	}
+	if valUsed {
+		as.Lhs = append(as.Lhs, rs.Value)
+		as.Rhs = append(as.Rhs, &ast.IndexExpr{
+			X:     copyNode(rs.X, pos).(ast.Expr),
+			Index: &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: "0"},
+		})
+	}
+	return as, true
+}
+
+// loopOnce tries to replace a loop by its body, as if it only ran once.
+func (r *reducer) loopOnce(loop ast.Stmt) bool {
+	var stmts []ast.Stmt
+	var body *ast.BlockStmt
+	var deleted []ast.Node
+	switch x := loop.(type) {
+	case *ast.ForStmt:
+		if x.Init != nil {
+			stmts = append(stmts, x.Init)
+		}
+		body = x.Body
+		deleted = append(deleted, x.Cond, x.Post)
+	case *ast.RangeStmt:
+		bind, ok := r.rangeBindings(x)
+		if !ok {
+			return false
+		}
+		if bind != nil {
+			stmts = append(stmts, bind)
+		} else {
+			deleted = append(deleted, x.Key, x.Value)
+		}
+		deleted = append(deleted, x.X)
+		body = x.Body
+	}
+	return r.unwrapStmt(loop, body.Lbrace, stmts, &body.List, deleted)
+}
+
+// unwrapStmt tries to replace a loop or select statement by a block with
+// the statements in pre followed by those in body. The break and continue
+// statements for it are removed, as is its label if it has one.
+func (r *reducer) unwrapStmt(stmt ast.Stmt, lbrace token.Pos, pre []ast.Stmt, body *[]ast.Stmt, deleted []ast.Node) bool {
+	label := r.stmtLabel(stmt)
+	branches, ok := r.stmtBranches(stmt, *body, label)
+	if !ok {
+		return false
+	}
+	var old ast.Stmt = stmt
+	if label != nil {
+		old = label
+	}
+	for _, bs := range branches {
+		if r.parentStmts(bs) == nil {
+			return false
+		}
+	}
+	var undos []func()
+	for _, bs := range branches {
+		undos = append(undos, r.replaceStmts(bs, nil))
+	}
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	block := &ast.BlockStmt{
+		Lbrace: old.Pos(),
+		List:   append(append([]ast.Stmt(nil), pre...), *body...),
+		Rbrace: old.End(),
+	}
+	undos = append(undos, r.replaceStmts(old, []ast.Stmt{block}))
+	parents := make([]ast.Node, len(pre))
+	for i, stmt := range pre {
+		// so that afterDelete can find declarations in them
+		parents[i] = r.parents[stmt]
+		r.parents[stmt] = block
+	}
+	r.afterDelete(deleted...)
+	if !r.okChange() {
+		undoAll()
+		for i, stmt := range pre {
+			r.parents[stmt] = parents[i]
+		}
+		return false
+	}
+	r.mergeLines(old.Pos(), lbrace+1)
+	r.setParents(block, r.parents[old])
+	return true
+}
+
+// typeExpr returns an expression for a type, or nil if it can't be
+// written in the current file, such as when it's declared in another
+// package.
+func (r *reducer) typeExpr(t types.Type, pos token.Pos) ast.Expr {
+	pkg := r.filePkg(r.file).types
+	local := true
+	qualifier := func(other *types.Package) string {
+		if other != pkg {
+			local = false
+		}
+		return ""
+	}
+	s := types.TypeString(t, qualifier)
+	if !local {
+		return nil
+	}
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil
+	}
+	return copyNode(expr, pos).(ast.Expr)
+}
+
+// emptyValue returns an expression for an empty value of a type to range
+// over, or nil if there is none that can be written in the current
+// file.
+func (r *reducer) emptyValue(t types.Type, pos token.Pos) ast.Expr {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			return &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: `""`}
+		case u.Info()&types.IsInteger != 0:
+			return &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: "0"}
+		}
+	case *types.Slice, *types.Map, *types.Array:
+		if typ := r.typeExpr(t, pos); typ != nil {
+			return &ast.CompositeLit{Type: typ, Lbrace: pos, Rbrace: pos}
+		}
+	}
+	return nil
+}
+
+// reduceLoopStmt tries to simplify a for or range loop, reporting whether it
+// changed anything.
+func (r *reducer) reduceLoopStmt(loop ast.Stmt) bool {
+	if r.loopOnce(loop) {
+		r.logChange(loop, "loop -> body once")
+		return true
+	}
+	switch x := loop.(type) {
+	case *ast.ForStmt:
+		if x.Init != nil {
+			init := x.Init
+			x.Init = nil
+			r.afterDelete(init)
+			if r.okChange() {
+				r.logChange(init, "removed for init")
+				return true
+			}
+			x.Init = init
+		}
+		// without a post or cond, the loop may never end
+		if *timeout <= 0 {
+			break
+		}
+		if x.Post != nil {
+			post := x.Post
+			x.Post = nil
+			r.afterDelete(post)
+			if r.okChange() {
+				r.logChange(post, "removed for post")
+				return true
+			}
+			x.Post = post
+		}
+		if x.Cond != nil {
+			cond := x.Cond
+			x.Cond = nil
+			r.afterDelete(cond)
+			if r.okChange() {
+				r.logChange(cond, "removed for cond")
+				return true
+			}
+			x.Cond = cond
+		}
+	case *ast.RangeStmt:
+		if lit, _ := x.X.(*ast.BasicLit); lit != nil {
+			break
+		}
+		if cl, _ := x.X.(*ast.CompositeLit); cl != nil && len(cl.Elts) == 0 {
+			break
+		}
+		tv, ok := r.info.Types[x.X]
+		if !ok || tv.Value != nil {
+			break
+		}
+		empty := r.emptyValue(tv.Type, x.X.Pos())
+		if empty == nil {
+			break
+		}
+		r.afterDelete(x.X)
+		if r.changedExpr(x.X, empty) {
+			r.logChange(x, "range x -> range T{}")
+			return true
+		}
+	}
+	return false
+}
+
+// removeBranch tries to remove a labeled branch statement. If it was the
+// only use of its label, the label is removed too.
+func (r *reducer) removeBranch(bs *ast.BranchStmt) bool {
+	if bs.Label == nil || r.parentStmts(bs) == nil {
+		return false
+	}
+	obj := r.info.Uses[bs.Label]
+	undos := []func(){r.replaceStmts(bs, nil)}
+	label, _ := r.parents[r.revDefs[obj]].(*ast.LabeledStmt)
+	if label != nil && len(r.useIdents[obj]) == 1 {
+		if ref := r.stmtRef(label); ref != nil {
+			*ref = label.Stmt
+			undos = append(undos, func() { *ref = label })
+		}
+	}
+	if !r.okChange() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+		return false
+	}
+	r.mergeLines(bs.Pos(), bs.End()+1)
+	if len(undos) > 1 {
+		r.mergeLines(label.Pos(), label.Stmt.Pos())
+		r.parents[label.Stmt] = r.parents[label]
+		r.logChange(bs, "removed %s and its label", bs.Tok)
+	} else {
+		r.logChange(bs, "removed %s %s", bs.Tok, bs.Label.Name)
+	}
+	return true
+}
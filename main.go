@@ -10,20 +10,33 @@ import (
 )
 
 var (
-	matchStr = flag.String("match", "", "regexp to match the output")
-	shellStr = flag.String("run", "", "shell command to test reductions")
-	verbose  = flag.Bool("v", false, "log applied changes to stderr")
+	matchStr    = flag.String("match", "", "regexp to match the output")
+	stdoutStr   = flag.String("stdout", "", "regexp to match stdout alone")
+	stderrStr   = flag.String("stderr", "", "regexp to match stderr alone")
+	exitStr     = flag.String("exit", "", "exit statuses to match, like 2,SIGSEGV or nonzero")
+	notMatchStr = flag.String("not-match", "", "regexp that the output must not match")
+	anyPred     = flag.Bool("any", false, "require any of the matches to succeed, not all")
+	shellStr    = flag.String("run", "", "shell command to test reductions")
+	verbose     = flag.Bool("v", false, "log applied changes to stderr")
+	deps        = flag.Bool("deps", false, "also reduce the imported packages from the same module")
+	testName    = flag.String("test", "", "name of a failing test to reduce, including test files")
+	exported    = flag.Bool("exported", false, "also remove and rename exported declarations from non-main packages")
+	buildTags   = flag.String("tags", "", "comma-separated list of build tags to satisfy")
+	timeout     = flag.Duration("timeout", 0, "kill the shell command if it runs for longer")
+	hang        = flag.Bool("hang", false, "reduce a hang, treating timing out as the error")
+	jobs        = flag.Int("j", 1, "number of changes to try in parallel")
 
 	shellStrBuild = `go build -ldflags "-w -s"`
 	shellStrRun   = `go build -ldflags "-w -s" -o out && ./out`
+	shellStrTest  = `go test -count=1 -run '^%s$'`
 )
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr,
-			"Usage: goreduce -match=re [-run=cmd] dir\n")
+			"Usage: goreduce [-match=re] [-run=cmd] dir\n")
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, `
+		fmt.Fprint(os.Stderr, `
 If -run=cmd is omitted, the default for non-main packages is:
 
   `+shellStrBuild+`
@@ -32,8 +45,55 @@ And for main packages:
 
   `+shellStrRun+`
 
+With -test=name, the package's test files are included, and the
+default is:
+
+  `+fmt.Sprintf(shellStrTest, "name")+`
+
+Files are selected following build constraints, using the tags from
+-tags as well as $GOOS and $GOARCH. The tags are passed on to the shell
+code via $GOFLAGS. Files excluded by build constraints are deleted if
+the result is still interesting without them.
+
 The shell code is run in a Bash-compatible shell interpreter. The
-package being reduced will be in its current directory.
+package being reduced will be in its current directory. If the package
+is part of a module, it keeps its import path, and the module's go.mod
+and go.sum are used to build it.
+
+A run of the shell code is interesting if its combined output matches
+-match, its stdout and stderr match -stdout and -stderr, and its exit
+status is one of those listed in -exit, where signals count as 128+n.
+At least one of them must be given. With -any, a run is interesting if
+any of those given match instead. Either way, the output must not match
+-not-match, which helps keep the reduction from drifting into a
+different bug.
+
+Only those two ways of combining the matches are supported: all of them,
+or any of them with -any. The only finer choice is within -exit, such as
+-stderr=X -exit=2,SIGSEGV for stderr matching X and an exit status of
+either 2 or SIGSEGV. Anything more complex, such as grouping matches in
+parentheses, has to be done by the shell code itself, for example by
+calling a script which exits with a status that -exit then matches.
+
+With -timeout=d, each command run by the shell code is killed along with
+all of its child processes if it runs for longer than d. That doesn't
+apply to go build, go install and go vet, so that slow builds aren't
+mistaken for hangs, but it does to go run and go test. Runs with a
+command killed this way are not interesting, unless -hang is used, in
+which case only those runs are. The regexps must then match the output
+produced before being killed, so -match may be omitted, and -exit cannot
+be used.
+
+Declarations which aren't used are removed, but exported ones are kept
+in non-main packages, as they might be used elsewhere. Use -exported to
+remove them too.
+
+Once no more changes work, names are shortened to ones like f, T and
+x, keeping those that the output depends on. Exported names are kept
+in the same way as above, as are methods.
+
+With -j=n, up to n changes are tried at once, each in a copy of the
+work directory. The result is the same as when trying one at a time.
 
 To catch a run-time error/crash entering main:
 
@@ -43,15 +103,19 @@ To catch a build error/crash with custom build flags:
 
   goreduce -match 'internal compiler error' . 'go build -gcflags "-c=2"'
 
+To catch a hang at run-time:
+
+  goreduce -hang -timeout 10s .
+
 Note that you may also call a script or any other program.
 `)
 	}
-	flag.Parse()
 }
 
 func main() {
+	flag.Parse()
 	args := flag.Args()
-	if len(args) < 1 || *matchStr == "" {
+	if len(args) < 1 {
 		flag.Usage()
 		os.Exit(2)
 	}
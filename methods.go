@@ -0,0 +1,247 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// implementsIface reports whether t or *t implements an interface with
+// a method by the given name, among the interfaces used in the packages.
+// Converting such a method to a func would likely break the program.
+func (r *reducer) implementsIface(t types.Type, name string) bool {
+	seen := make(map[*types.Interface]bool)
+	check := func(t2 types.Type) bool {
+		iface, _ := t2.Underlying().(*types.Interface)
+		if iface == nil || seen[iface] {
+			return false
+		}
+		seen[iface] = true
+		for i := 0; i < iface.NumMethods(); i++ {
+			if iface.Method(i).Name() != name {
+				continue
+			}
+			return types.Implements(t, iface) ||
+				types.Implements(types.NewPointer(t), iface)
+		}
+		return false
+	}
+	if check(types.Universe.Lookup("error").Type()) {
+		return true
+	}
+	for _, tv := range r.info.Types {
+		if tv.Type != nil && check(tv.Type) {
+			return true
+		}
+	}
+	for _, obj := range r.info.Defs {
+		if obj != nil && check(obj.Type()) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodToFunc tries to turn a method into a func which takes the
+// receiver as its first parameter, as in func M(t T, a A). Calls like
+// x.M(a) become M(x, a), method expressions like T.M become M, and
+// method values like x.M become func literals calling M.
+func (r *reducer) methodToFunc(fd *ast.FuncDecl) bool {
+	if fd.Recv == nil || len(fd.Recv.List) != 1 || fd.Body == nil {
+		return false
+	}
+	recv := fd.Recv.List[0]
+	if len(recv.Names) != 1 || !r.removableName(fd.Name) {
+		return false
+	}
+	if len(r.useIdents[r.info.Defs[recv.Names[0]]]) == 0 {
+		return false // left to the rule removing the receiver
+	}
+	for _, field := range fd.Type.Params.List {
+		if len(field.Names) == 0 {
+			return false // can't mix named and unnamed params
+		}
+	}
+	obj := r.info.Defs[fd.Name].(*types.Func)
+	sign := obj.Type().(*types.Signature)
+	recvType := sign.Recv().Type()
+	_, ptrRecv := recvType.(*types.Pointer)
+	named := recvType
+	if ptr, ok := named.(*types.Pointer); ok {
+		named = ptr.Elem()
+	}
+	if n, _ := named.(*types.Named); n == nil || n.TypeParams() != nil {
+		return false
+	}
+	if r.implementsIface(named, fd.Name.Name) {
+		return false
+	}
+	tn := named.(*types.Named).Obj()
+	for _, use := range r.useIdents[tn] {
+		if r.info.Defs[use] != nil { // struct{ T }, promoting M
+			return false
+		}
+	}
+	lp := r.filePkg(r.file)
+	var poss []token.Pos
+	for _, use := range r.useIdents[obj] {
+		poss = append(poss, use.Pos())
+	}
+	newName := fd.Name.Name
+	for r.nameTaken(newName, lp, poss...) {
+		newName += "_"
+	}
+
+	var undos []func()
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	var nodes []ast.Node
+	var added, parents []ast.Node
+	for _, use := range r.useIdents[obj] {
+		sel, _ := r.parents[use].(*ast.SelectorExpr)
+		if sel == nil || sel.Sel != use || r.filePkg(r.nodeFile(use)) != lp {
+			undoAll()
+			return false
+		}
+		selection := r.info.Selections[sel]
+		if selection == nil || len(selection.Index()) > 1 {
+			undoAll()
+			return false
+		}
+		ref := r.exprRef(sel)
+		if ref == nil {
+			undoAll()
+			return false
+		}
+		fun := &ast.Ident{NamePos: sel.Pos(), Name: newName}
+		var with ast.Expr
+		switch selection.Kind() {
+		case types.MethodExpr: // T.M or (*T).M
+			_, ptrExpr := r.info.TypeOf(sel.X).(*types.Pointer)
+			if ptrExpr != ptrRecv {
+				undoAll()
+				return false
+			}
+			with = fun
+		case types.MethodVal:
+			arg := sel.X
+			_, ptrArg := r.info.TypeOf(arg).(*types.Pointer)
+			switch {
+			case ptrRecv && !ptrArg:
+				arg = &ast.UnaryExpr{OpPos: arg.Pos(), Op: token.AND, X: arg}
+			case !ptrRecv && ptrArg:
+				arg = &ast.StarExpr{Star: arg.Pos(), X: arg}
+			}
+			if ce, _ := r.parents[sel].(*ast.CallExpr); ce != nil && ce.Fun == sel {
+				oldArgs := ce.Args
+				ce.Fun = fun
+				ce.Args = append([]ast.Expr{arg}, oldArgs...)
+				undos = append(undos, func() {
+					ce.Fun = sel
+					ce.Args = oldArgs
+				})
+				nodes = append(nodes, use)
+				added = append(added, ce)
+				parents = append(parents, r.parents[ce])
+				continue
+			}
+			if with = r.methodValueFunc(selection, fun, arg); with == nil {
+				undoAll()
+				return false
+			}
+		default:
+			undoAll()
+			return false
+		}
+		*ref = with
+		undos = append(undos, func() { *ref = sel })
+		nodes = append(nodes, use)
+		added = append(added, with)
+		parents = append(parents, r.parents[sel])
+	}
+
+	oldRecv, oldName, oldParams := fd.Recv, fd.Name.Name, fd.Type.Params
+	params := *oldParams
+	params.List = append([]*ast.Field{recv}, oldParams.List...)
+	fd.Recv, fd.Name.Name, fd.Type.Params = nil, newName, &params
+	undos = append(undos, func() {
+		fd.Recv, fd.Name.Name, fd.Type.Params = oldRecv, oldName, oldParams
+	})
+	nodes = append(nodes, fd)
+	if !r.tryEdits(nodes, nil, undos) {
+		return false
+	}
+	r.setParents(fd, r.parents[fd])
+	for i, node := range added {
+		r.setParents(node, parents[i])
+	}
+	r.logChange(fd, "method -> func")
+	return true
+}
+
+// nameTaken reports whether a name for a new top-level func in a package
+// would clash with another name, or be shadowed at any of the positions.
+func (r *reducer) nameTaken(name string, lp *localPkg, poss ...token.Pos) bool {
+	scope := lp.types.Scope()
+	if scope.Lookup(name) != nil || types.Universe.Lookup(name) != nil {
+		return true
+	}
+	for i := 0; i < scope.NumChildren(); i++ {
+		if scope.Child(i).Lookup(name) != nil { // e.g. an import
+			return true
+		}
+	}
+	for _, pos := range poss {
+		if inner := scope.Innermost(pos); inner != nil {
+			if _, obj2 := inner.LookupParent(name, pos); obj2 != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// methodValueFunc returns a func literal to replace the method value
+// x.M, given its selection, the func that M became, and the expression
+// to pass as its receiver. It returns nil if the func type can't be
+// written in the current file.
+func (r *reducer) methodValueFunc(selection *types.Selection, fun, arg ast.Expr) ast.Expr {
+	pos := fun.Pos()
+	sign := selection.Type().(*types.Signature)
+	ftype, _ := r.typeExpr(sign, pos).(*ast.FuncType)
+	if ftype == nil {
+		return nil
+	}
+	call := &ast.CallExpr{Fun: fun, Lparen: pos, Args: []ast.Expr{arg}, Rparen: pos}
+	if ftype.Params != nil {
+		i := 0
+		for _, field := range ftype.Params.List {
+			name := "x" + strconv.Itoa(i)
+			for mentions(arg, name) {
+				name += "_"
+			}
+			field.Names = []*ast.Ident{{NamePos: pos, Name: name}}
+			call.Args = append(call.Args, &ast.Ident{NamePos: pos, Name: name})
+			if _, ok := field.Type.(*ast.Ellipsis); ok {
+				call.Ellipsis = pos
+			}
+			i++
+		}
+	}
+	var stmt ast.Stmt = &ast.ExprStmt{X: call}
+	if sign.Results().Len() > 0 {
+		stmt = &ast.ReturnStmt{Return: pos, Results: []ast.Expr{call}}
+	}
+	return &ast.FuncLit{Type: ftype, Body: &ast.BlockStmt{
+		Lbrace: pos,
+		List:   []ast.Stmt{stmt},
+		Rbrace: pos,
+	}}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findModule walks up from dir looking for a go.mod file, returning the
+// root directory of the enclosing module or an empty string if there is
+// none.
+func findModule(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadModule finds the module enclosing dir, if any, setting
+// r.modRoot and r.modPath. If dir is relative, so is r.modRoot.
+func (r *reducer) loadModule(dir string) error {
+	root, err := findModule(dir)
+	if err != nil || root == "" {
+		return err
+	}
+	mod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(dir) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if root, err = filepath.Rel(wd, root); err != nil {
+			return err
+		}
+	}
+	r.modRoot = root
+	r.modPath = modulePath(mod)
+	return nil
+}
+
+// modImportPath returns the import path of the package in dir, which
+// must be inside the module at r.modRoot.
+func (r *reducer) modImportPath(dir string) (string, error) {
+	rel, err := filepath.Rel(r.modRoot, dir)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return r.modPath, nil
+	}
+	return r.modPath + "/" + filepath.ToSlash(rel), nil
+}
+
+// modDir returns the directory holding the package with the given
+// import path, and whether the package is part of r.modPath at all.
+func (r *reducer) modDir(path string) (string, bool) {
+	if r.modRoot == "" {
+		return "", false
+	}
+	if path == r.modPath {
+		return r.modRoot, true
+	}
+	if !strings.HasPrefix(path, r.modPath+"/") {
+		return "", false
+	}
+	rel := filepath.FromSlash(path[len(r.modPath)+1:])
+	return filepath.Join(r.modRoot, rel), true
+}
+
+// setupWorkDir prepares root to build the packages being reduced,
+// setting the directory each of them is to be written to.
+//
+// If the packages are inside a module, its go.mod and go.sum are
+// reproduced at root and the rest of the module is linked into place,
+// so that the packages keep their import paths and can still import
+// their siblings and dependencies. Otherwise, the package is built as
+// a module of its own, with the language version of the toolchain like
+// the go tool does for code outside of modules.
+func (r *reducer) setupWorkDir(root string) error {
+	if r.modRoot == "" {
+		for _, lp := range r.pkgs {
+			lp.tdir = root
+		}
+		tags := r.buildCtx.ReleaseTags
+		version := strings.TrimPrefix(tags[len(tags)-1], "go")
+		return ioutil.WriteFile(
+			filepath.Join(root, "go.mod"),
+			[]byte("module tmp\n\ngo "+version+"\n"), 0666,
+		)
+	}
+	modRoot, err := filepath.Abs(r.modRoot)
+	if err != nil {
+		return err
+	}
+	mod, err := ioutil.ReadFile(filepath.Join(modRoot, "go.mod"))
+	if err != nil {
+		return err
+	}
+	mod = absReplaces(mod, modRoot)
+	if err := ioutil.WriteFile(filepath.Join(root, "go.mod"), mod, 0666); err != nil {
+		return err
+	}
+	sum, err := ioutil.ReadFile(filepath.Join(modRoot, "go.sum"))
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(root, "go.sum"), sum, 0666)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rels := make([]string, len(r.pkgs))
+	for i, lp := range r.pkgs {
+		dir, err := filepath.Abs(lp.dir)
+		if err != nil {
+			return err
+		}
+		if rels[i], err = filepath.Rel(modRoot, dir); err != nil {
+			return err
+		}
+		lp.tdir = filepath.Join(root, rels[i])
+	}
+	return linkDir(modRoot, root, rels, true)
+}
+
+// linkDir mirrors the directory src into dst via symlinks, except for
+// the directories leading to the package directories at rels, which
+// are created as real directories. The Go files in the package
+// directories are left out, as the reducer writes its own versions of
+// them. If top is true, go.mod and go.sum are left out too, as they
+// were already copied.
+func linkDir(src, dst string, rels []string, top bool) error {
+	isPkg := false
+	subRels := make(map[string][]string)
+	for _, rel := range rels {
+		if rel == "." {
+			isPkg = true
+			continue
+		}
+		elems := strings.SplitN(rel, string(filepath.Separator), 2)
+		rest := "."
+		if len(elems) == 2 {
+			rest = elems[1]
+		}
+		subRels[elems[0]] = append(subRels[elems[0]], rest)
+	}
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if rels, e := subRels[name]; e {
+			sub := filepath.Join(dst, name)
+			if err := os.Mkdir(sub, 0777); err != nil {
+				return err
+			}
+			if err := linkDir(filepath.Join(src, name), sub, rels, false); err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case top && (name == "go.mod" || name == "go.sum"):
+			continue
+		case isPkg && !info.IsDir() && strings.HasSuffix(name, ".go"):
+			continue
+		}
+		if err := os.Symlink(filepath.Join(src, name),
+			filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modulePath returns the module path declared in a go.mod file.
+func modulePath(mod []byte) string {
+	for _, line := range strings.Split(string(mod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// absReplaces rewrites the relative paths in the replace directives of
+// a go.mod file to be absolute, as the file is moved out of root.
+func absReplaces(mod []byte, root string) []byte {
+	lines := strings.Split(string(mod), "\n")
+	inBlock := false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "replace" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "replace", inBlock:
+		default:
+			continue
+		}
+		for j, field := range fields[:len(fields)-1] {
+			if field != "=>" {
+				continue
+			}
+			path := fields[j+1]
+			if !strings.HasPrefix(path, "./") && !strings.HasPrefix(path, "../") {
+				break
+			}
+			k := strings.LastIndex(line, path)
+			lines[i] = line[:k] + filepath.Join(root, path) + line[k+len(path):]
+			break
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"context"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// worker is a copy of the work directory, in which changes are tried in
+// parallel with the other workers.
+type worker struct {
+	root string // work directory
+	dir  string // where the shell code is run
+
+	// written holds the last contents written for each file
+	written map[*ast.File]string
+}
+
+// candidate is a change found while walking the packages, to be tried
+// by one of the workers.
+type candidate struct {
+	key   string
+	files []*ast.File
+	srcs  [][]byte
+	err   error
+}
+
+// setupWorkers prepares the extra work directories for -j, the first
+// worker being the main work directory at root.
+func (r *reducer) setupWorkers(root string) (cleanup func(), err error) {
+	var roots []string
+	cleanup = func() {
+		for _, root := range roots {
+			os.RemoveAll(root)
+		}
+	}
+	tdirs := make([]string, len(r.pkgs))
+	for i, lp := range r.pkgs {
+		tdirs[i] = lp.tdir
+	}
+	rel, err := filepath.Rel(root, r.tdir)
+	if err != nil {
+		return cleanup, err
+	}
+	r.workers = append(r.workers, &worker{root: root, dir: r.tdir})
+	for len(r.workers) < *jobs {
+		wroot, err := ioutil.TempDir("", "goreduce")
+		if err != nil {
+			return cleanup, err
+		}
+		roots = append(roots, wroot)
+		if err := r.setupWorkDir(wroot); err != nil {
+			return cleanup, err
+		}
+		r.workers = append(r.workers, &worker{
+			root: wroot,
+			dir:  filepath.Join(wroot, rel),
+		})
+	}
+	for i, lp := range r.pkgs {
+		lp.tdir = tdirs[i]
+	}
+	for _, w := range r.workers {
+		w.written = make(map[*ast.File]string)
+	}
+	return cleanup, nil
+}
+
+// batchFull reports whether as many changes as there are workers have
+// been found, and the walk should stop until they are tried.
+func (r *reducer) batchFull() bool {
+	return r.workers != nil && len(r.pending) >= len(r.workers)
+}
+
+// addPending adds a change to be tried by the workers, unless it is
+// already pending.
+func (r *reducer) addPending(key string, files []*ast.File, srcs [][]byte) {
+	for _, c := range r.pending {
+		if c.key == key {
+			return
+		}
+	}
+	r.pending = append(r.pending, &candidate{key: key, files: files, srcs: srcs})
+}
+
+// tryPending tries all the pending changes at once, one per worker. The
+// results are kept so that walking the packages again replays them in
+// order, which accepts the first change that worked. That is the same
+// change that trying them one at a time would have accepted, so the
+// output does not depend on -j. Once a change works, those after it are
+// cancelled, as they will not be replayed; they might never finish.
+func (r *reducer) tryPending() {
+	// files as they are, before any of the changes
+	base := make(map[*ast.File]string, len(r.tmpFiles))
+	for file := range r.tmpFiles {
+		r.dstBuf.Reset()
+		if err := rawPrinter.Fprint(r.dstBuf, r.fset, file); err != nil {
+			panic(err)
+		}
+		base[file] = r.dstBuf.String()
+	}
+	ctxs := make([]context.Context, len(r.pending))
+	cancels := make([]context.CancelFunc, len(r.pending))
+	for i := range r.pending {
+		ctxs[i], cancels[i] = context.WithCancel(context.Background())
+	}
+	var wg sync.WaitGroup
+	for i, c := range r.pending {
+		i, w, c := i, r.workers[i], c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.err = r.tryCandidate(ctxs[i], w, base, c)
+			if c.err == nil {
+				for _, cancel := range cancels[i+1:] {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i, c := range r.pending {
+		if ctxs[i].Err() == nil {
+			r.results[c.key] = c.err
+		}
+		cancels[i]()
+	}
+	r.pending = r.pending[:0]
+}
+
+// tryCandidate writes a change into a worker's directory, and checks
+// whether the shell code still gives an interesting result.
+func (r *reducer) tryCandidate(ctx context.Context, w *worker, base map[*ast.File]string, c *candidate) error {
+	for file, src := range base {
+		for i, file2 := range c.files {
+			if file2 == file {
+				src = string(c.srcs[i])
+			}
+		}
+		if w.written[file] == src {
+			continue
+		}
+		rel, err := filepath.Rel(r.workers[0].root, r.tmpFiles[file].Name())
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(w.root, rel), []byte(src), 0666); err != nil {
+			return err
+		}
+		w.written[file] = src
+	}
+	return r.checkRun(ctx, w.dir)
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// nodeFile returns the file that a node belongs to.
+func (r *reducer) nodeFile(node ast.Node) *ast.File {
+	for node != nil {
+		if f, ok := node.(*ast.File); ok {
+			return f
+		}
+		node = r.parents[node]
+	}
+	return nil
+}
+
+// funcCalls returns all the calls to a func, including those made via
+// local variables holding it. It returns false if the func is used in
+// any other way, as changing its signature could break those uses.
+func (r *reducer) funcCalls(obj types.Object) ([]*ast.CallExpr, bool) {
+	var calls []*ast.CallExpr
+	for _, use := range r.useIdents[obj] {
+		var expr ast.Expr = use
+		if sel, _ := r.parents[use].(*ast.SelectorExpr); sel != nil && sel.Sel == use {
+			expr = sel // pkg.F or x.M
+		}
+		if ce, _ := r.parents[expr].(*ast.CallExpr); ce != nil && ce.Fun == expr {
+			calls = append(calls, ce)
+			continue
+		}
+		// v := f, where v is only ever called
+		as, _ := r.parents[expr].(*ast.AssignStmt)
+		if as == nil || as.Tok != token.DEFINE || len(as.Lhs) != len(as.Rhs) {
+			return nil, false
+		}
+		var vobj types.Object
+		for i, rhs := range as.Rhs {
+			if id, _ := as.Lhs[i].(*ast.Ident); rhs == expr && id != nil {
+				vobj = r.info.Defs[id]
+			}
+		}
+		if vobj == nil {
+			return nil, false
+		}
+		vcalls, ok := r.funcCalls(vobj)
+		if !ok {
+			return nil, false
+		}
+		calls = append(calls, vcalls...)
+	}
+	return calls, true
+}
+
+// funcReturns returns the return statements of a func body, excluding
+// those of func literals within it.
+func funcReturns(body *ast.BlockStmt) []*ast.ReturnStmt {
+	var rets []*ast.ReturnStmt
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			rets = append(rets, x)
+		}
+		return true
+	})
+	return rets
+}
+
+// fieldsIndex returns the field and the name within it that declare the
+// n-th entry of a parameter or result list. The name is nil if the field
+// has no names.
+func fieldsIndex(list *ast.FieldList, n int) (*ast.Field, *ast.Ident) {
+	for _, field := range list.List {
+		if len(field.Names) == 0 {
+			if n == 0 {
+				return field, nil
+			}
+			n--
+			continue
+		}
+		for _, name := range field.Names {
+			if n == 0 {
+				return field, name
+			}
+			n--
+		}
+	}
+	return nil, nil
+}
+
+// removeFromFields removes a parameter or result from a list, given the
+// field and name declaring it. The whole list is dropped if it's left
+// empty, which ref points to.
+func removeFromFields(ref **ast.FieldList, field *ast.Field, name *ast.Ident) (undo func()) {
+	list := *ref
+	if name != nil && len(field.Names) > 1 {
+		names := field.Names
+		for i, name2 := range names {
+			if name2 == name {
+				field.Names = append(names[:i:i], names[i+1:]...)
+			}
+		}
+		return func() { field.Names = names }
+	}
+	undoList := removeFromList(list, field)
+	if len(list.List) == 0 && list.Opening == token.NoPos {
+		*ref = nil // a single result, without parentheses
+	}
+	return func() {
+		undoList()
+		*ref = list
+	}
+}
+
+// reduceFuncSignature tries to remove the parameters and results of a
+// func which aren't used, adapting all of its calls and return
+// statements.
+func (r *reducer) reduceFuncSignature(fd *ast.FuncDecl) bool {
+	if fd.Body == nil || fd.Type.TypeParams != nil || !r.removableName(fd.Name) {
+		return false
+	}
+	if fd.Recv == nil && (fd.Name.Name == "main" || fd.Name.Name == "init") {
+		return false
+	}
+	sign := r.info.Defs[fd.Name].Type().(*types.Signature)
+	calls, ok := r.funcCalls(r.info.Defs[fd.Name])
+	if !ok {
+		return false
+	}
+	for i := 0; i < sign.Params().Len(); i++ {
+		if r.removedParam(fd, sign, calls, i) {
+			r.logChange(fd.Name, "removed func param")
+			return true
+		}
+	}
+	for i := 0; i < sign.Results().Len(); i++ {
+		if r.removedResult(fd, sign, calls, i) {
+			r.logChange(fd.Name, "removed func result")
+			return true
+		}
+	}
+	return false
+}
+
+// tryEdits sets r.otherFiles to those files touched by the edits apart
+// from r.file, tries the change, and undoes the edits if it failed.
+func (r *reducer) tryEdits(nodes []ast.Node, deleted []ast.Node, undos []func()) bool {
+	seen := map[*ast.File]bool{r.file: true}
+	for _, node := range nodes {
+		if f := r.nodeFile(node); f != nil && !seen[f] {
+			seen[f] = true
+			r.otherFiles = append(r.otherFiles, f)
+		}
+	}
+	r.afterDelete(deleted...)
+	ok := r.okChange()
+	r.otherFiles = nil
+	if !ok {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	return ok
+}
+
+// removedParam tries to remove the i-th parameter of a func, along with
+// the arguments passed to it.
+func (r *reducer) removedParam(fd *ast.FuncDecl, sign *types.Signature, calls []*ast.CallExpr, i int) bool {
+	param := sign.Params().At(i)
+	if len(r.useIdents[param]) > 0 {
+		return false
+	}
+	variadic := sign.Variadic() && i == sign.Params().Len()-1
+	field, name := fieldsIndex(fd.Type.Params, i)
+	var undos []func()
+	var nodes, deleted []ast.Node
+	for _, ce := range calls {
+		if ce.Ellipsis.IsValid() {
+			return false
+		}
+		if !variadic && len(ce.Args) != sign.Params().Len() {
+			return false // f(g()) with many results
+		}
+		args := ce.Args
+		end := i + 1
+		if variadic {
+			end = len(args)
+		}
+		for _, arg := range args[i:end] {
+			deleted = append(deleted, arg)
+		}
+		ce.Args = append(args[:i:i], args[end:]...)
+		ce := ce
+		undos = append(undos, func() { ce.Args = args })
+		nodes = append(nodes, ce)
+	}
+	undos = append(undos, removeFromFields(&fd.Type.Params, field, name))
+	deleted = append(deleted, field.Type)
+	return r.tryEdits(nodes, deleted, undos)
+}
+
+// removedResult tries to remove the i-th result of a func, along with
+// the values returned for it and the variables the calls assign it to.
+func (r *reducer) removedResult(fd *ast.FuncDecl, sign *types.Signature, calls []*ast.CallExpr, i int) bool {
+	res := sign.Results().At(i)
+	if len(r.useIdents[res]) > 0 {
+		return false
+	}
+	n := sign.Results().Len()
+	field, name := fieldsIndex(fd.Type.Results, i)
+	var undos []func()
+	var nodes, deleted []ast.Node
+	var exprStmts []*ast.ExprStmt
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	for _, ret := range funcReturns(fd.Body) {
+		if len(ret.Results) == 0 { // named results
+			continue
+		}
+		if len(ret.Results) != n { // return g()
+			undoAll()
+			return false
+		}
+		results := ret.Results
+		deleted = append(deleted, results[i])
+		ret.Results = append(results[:i:i], results[i+1:]...)
+		ret := ret
+		undos = append(undos, func() { ret.Results = results })
+	}
+	for _, ce := range calls {
+		switch x := r.parents[ce].(type) {
+		case *ast.ExprStmt, *ast.GoStmt, *ast.DeferStmt:
+		case *ast.AssignStmt:
+			if len(x.Rhs) != 1 || len(x.Lhs) != n {
+				undoAll()
+				return false
+			}
+			if id, _ := x.Lhs[i].(*ast.Ident); id != nil {
+				if obj := r.info.Defs[id]; obj != nil && len(r.useIdents[obj]) > 0 {
+					undoAll()
+					return false
+				}
+			}
+			if n == 1 {
+				if r.parentStmts(x) == nil {
+					undoAll()
+					return false
+				}
+				es := &ast.ExprStmt{X: ce}
+				undos = append(undos, r.replaceStmts(x, []ast.Stmt{es}))
+				r.parents[es] = r.parents[x]
+				exprStmts = append(exprStmts, es)
+				deleted = append(deleted, x.Lhs[0])
+				nodes = append(nodes, x)
+				continue
+			}
+			lhs, tok := x.Lhs, x.Tok
+			deleted = append(deleted, lhs[i])
+			x.Lhs = append(lhs[:i:i], lhs[i+1:]...)
+			r.fixAssignTok(x)
+			undos = append(undos, func() { x.Lhs, x.Tok = lhs, tok })
+			nodes = append(nodes, x)
+		default:
+			undoAll()
+			return false
+		}
+	}
+	undos = append(undos, removeFromFields(&fd.Type.Results, field, name))
+	deleted = append(deleted, field.Type)
+	if !r.tryEdits(nodes, deleted, undos) {
+		return false
+	}
+	for _, es := range exprStmts {
+		r.parents[es.X] = es
+	}
+	return true
+}
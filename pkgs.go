@@ -0,0 +1,447 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localPkg is one of the packages being reduced.
+type localPkg struct {
+	path string // import path
+	dir  string // directory with the original source
+	tdir string // directory with the source being reduced
+
+	pkg   *ast.Package
+	files []*ast.File // sorted by filename
+	types *types.Package
+
+	// excluded are the files left out by build constraints, which
+	// are only ever removed
+	excluded []*ast.File
+}
+
+// loadPkg parses the package in dir, leaving out its test files.
+func (r *reducer) loadPkg(path, dir string) (*localPkg, error) {
+	lp, _, err := r.loadPkgs(path, dir, false)
+	return lp, err
+}
+
+// loadPkgs parses the package in dir. If tests is true, its test files
+// are included, and its external test package is returned too if there
+// is one.
+func (r *reducer) loadPkgs(path, dir string, tests bool) (lp, xtest *localPkg, err error) {
+	var excluded []string
+	kept := make(map[string]bool)
+	filter := func(info os.FileInfo) bool {
+		name := info.Name()
+		if !tests && strings.HasSuffix(name, "_test.go") {
+			return false
+		}
+		match, err := r.buildCtx.MatchFile(dir, name)
+		if err == nil && !match {
+			excluded = append(excluded, filepath.Join(dir, name))
+			return false
+		}
+		kept[name] = true
+		return true
+	}
+	pkgs, err := parser.ParseDir(r.fset, dir, filter, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	var excludedFiles []*ast.File
+	for _, fname := range excluded {
+		f, err := parser.ParseFile(r.fset, fname, nil, parser.ParseComments)
+		if err == nil {
+			excludedFiles = append(excludedFiles, f)
+		}
+	}
+	// parsed again to keep the original line numbers for logChange;
+	// the files are added in the same order, so positions match
+	parser.ParseDir(r.origFset, dir, func(info os.FileInfo) bool {
+		return kept[info.Name()]
+	}, 0)
+	for _, fname := range excluded {
+		parser.ParseFile(r.origFset, fname, nil, 0)
+	}
+	for name, pkg := range pkgs {
+		if tests && len(pkgs) == 2 && strings.HasSuffix(name, "_test") {
+			xtest = newLocalPkg(path+"_test", dir, pkg)
+		} else {
+			lp = newLocalPkg(path, dir, pkg)
+		}
+	}
+	if lp == nil || len(pkgs) > 2 || len(pkgs) == 2 && xtest == nil {
+		return nil, nil, fmt.Errorf("expected 1 package, got %d", len(pkgs))
+	}
+	lp.excluded = excludedFiles
+	return lp, xtest, nil
+}
+
+func newLocalPkg(path, dir string, pkg *ast.Package) *localPkg {
+	lp := &localPkg{path: path, dir: dir, pkg: pkg}
+	names := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lp.files = append(lp.files, pkg.Files[name])
+	}
+	return lp
+}
+
+// allFiles returns the files of the package, followed by those excluded
+// by build constraints.
+func (lp *localPkg) allFiles() []*ast.File {
+	files := make([]*ast.File, 0, len(lp.files)+len(lp.excluded))
+	files = append(files, lp.files...)
+	return append(files, lp.excluded...)
+}
+
+// loadDeps loads the packages from the same module that lp imports,
+// directly or indirectly, appending them to r.pkgs after the packages
+// they import.
+func (r *reducer) loadDeps(lp *localPkg, seen map[string]bool) error {
+	for _, path := range lp.imports() {
+		dir, ok := r.modDir(path)
+		if !ok || seen[path] {
+			continue
+		}
+		seen[path] = true
+		dep, err := r.loadPkg(path, dir)
+		if err != nil {
+			return err
+		}
+		if err := r.loadDeps(dep, seen); err != nil {
+			return err
+		}
+		r.pkgs = append(r.pkgs, dep)
+	}
+	return nil
+}
+
+// imports returns the paths imported by the package, in order of
+// appearance.
+func (lp *localPkg) imports() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, f := range lp.files {
+		for _, imp := range fileImports(f) {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// fileImports returns the imports in a file. Unlike ast.File.Imports, it
+// takes removed imports into account.
+func fileImports(f *ast.File) []*ast.ImportSpec {
+	var imps []*ast.ImportSpec
+	for _, decl := range f.Decls {
+		gd, _ := decl.(*ast.GenDecl)
+		if gd == nil || gd.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			imps = append(imps, spec.(*ast.ImportSpec))
+		}
+	}
+	return imps
+}
+
+// mainPkg returns the package that reduce was called on.
+func (r *reducer) mainPkg() *localPkg {
+	for _, lp := range r.pkgs {
+		if lp.pkg == r.pkg {
+			return lp
+		}
+	}
+	return nil
+}
+
+// filePkg returns the package that a file belongs to.
+func (r *reducer) filePkg(f *ast.File) *localPkg {
+	for _, lp := range r.pkgs {
+		for _, f2 := range lp.files {
+			if f2 == f {
+				return lp
+			}
+		}
+	}
+	return nil
+}
+
+// isLocal reports whether pkg is one of the packages being reduced.
+func (r *reducer) isLocal(pkg *types.Package) bool {
+	if pkg == nil {
+		return false
+	}
+	for _, lp := range r.pkgs {
+		if lp.types == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// loadExports sets r.importer to load the packages imported by the
+// packages being reduced from their export data. The go tool is asked
+// for it from the work directory, with the same environment as the
+// shell code, so that modules, vendoring and cgo are resolved just like
+// when building.
+func (r *reducer) loadExports() error {
+	local := make(map[string]bool)
+	for _, lp := range r.pkgs {
+		local[lp.path] = true
+	}
+	var paths []string
+	for _, lp := range r.pkgs {
+		for _, path := range lp.imports() {
+			if !local[path] && path != "C" {
+				local[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	exports := make(map[string]string)
+	r.importer = importer.ForCompiler(token.NewFileSet(), "gc", func(path string) (io.ReadCloser, error) {
+		export := exports[path]
+		if export == "" {
+			return nil, fmt.Errorf("no export data for %q", path)
+		}
+		return os.Open(export)
+	})
+	if len(paths) == 0 {
+		return nil
+	}
+	args := []string{"list", "-e", "-export", "-deps", "-json=ImportPath,Export,Error"}
+	cmd := exec.Command("go", append(args, paths...)...)
+	cmd.Dir = r.tdir
+	cmd.Env = r.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go list: %v: %s", err, stderr.Bytes())
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p struct {
+			ImportPath string
+			Export     string
+			Error      *struct{ Err string }
+		}
+		if err := dec.Decode(&p); err != nil {
+			return err
+		}
+		if p.Error != nil {
+			return fmt.Errorf("%s: %s", p.ImportPath, p.Error.Err)
+		}
+		exports[p.ImportPath] = p.Export
+	}
+	return nil
+}
+
+// Import implements types.Importer, resolving the packages being
+// reduced to their last type-checked versions.
+func (r *reducer) Import(path string) (*types.Package, error) {
+	for _, lp := range r.pkgs {
+		if lp.path == path && lp.types != nil {
+			return lp.types, nil
+		}
+	}
+	return r.importer.Import(path)
+}
+
+// checkPkgs type-checks all the packages being reduced, filling r.info.
+// The first error found is returned.
+func (r *reducer) checkPkgs() error {
+	for _, lp := range r.pkgs {
+		lp.types = nil
+	}
+	var firstErr error
+	for _, lp := range r.pkgs {
+		var err error
+		lp.types, err = r.tconf.Check(lp.path, r.fset, lp.files, r.info)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pruneDeps drops the packages which the main package no longer
+// imports, directly or indirectly. Their files are deleted at the end.
+func (r *reducer) pruneDeps() {
+	used := make(map[string]bool)
+	for _, lp := range r.pkgs {
+		// the main package, and its external tests if any
+		if lp.dir == r.mainPkg().dir {
+			used[lp.path] = true
+		}
+	}
+	// importers come after the packages they import
+	for i := len(r.pkgs) - 1; i >= 0; i-- {
+		lp := r.pkgs[i]
+		if !used[lp.path] {
+			continue
+		}
+		for _, path := range lp.imports() {
+			used[path] = true
+		}
+	}
+	pkgs := make([]*localPkg, 0, len(r.pkgs))
+	for _, lp := range r.pkgs {
+		if used[lp.path] {
+			pkgs = append(pkgs, lp)
+			continue
+		}
+		for _, f := range lp.allFiles() {
+			delete(r.tmpFiles, f)
+		}
+		r.prunedPkgs = append(r.prunedPkgs, lp)
+		if *verbose {
+			fmt.Fprintf(r.logOut, "%s: package no longer imported\n", lp.path)
+		}
+	}
+	r.pkgs = pkgs
+}
+
+// removeFile tries to empty a file entirely, as long as its package
+// has other files left. Removed files are deleted from disk at the end.
+func (r *reducer) removeFile(f *ast.File) {
+	if r.removedFiles[f] {
+		return
+	}
+	left := 0
+	for _, f2 := range r.filePkg(f).files {
+		if !r.removedFiles[f2] {
+			left++
+		}
+	}
+	if left < 2 {
+		return
+	}
+	oldDecls, oldComments, oldDoc := f.Decls, f.Comments, f.Doc
+	f.Decls, f.Comments, f.Doc = nil, nil, nil
+	if r.okChange() {
+		r.removedFiles[f] = true
+		r.logChange(f, "removed file")
+		return
+	}
+	f.Decls, f.Comments, f.Doc = oldDecls, oldComments, oldDoc
+}
+
+// removeExcluded tries to empty the files of lp excluded by build
+// constraints, one at a time. As the constraints go too, the files are
+// left with just the package clause of lp. Removed files are deleted
+// from disk at the end.
+func (r *reducer) removeExcluded(lp *localPkg) {
+	for _, f := range lp.excluded {
+		if r.removedFiles[f] {
+			continue
+		}
+		oldDecls, oldComments, oldDoc, oldName := f.Decls, f.Comments, f.Doc, f.Name
+		f.Decls, f.Comments, f.Doc = nil, nil, nil
+		f.Name = &ast.Ident{NamePos: f.Name.NamePos, Name: lp.pkg.Name}
+		r.otherFiles = []*ast.File{f}
+		ok := r.okChange()
+		r.otherFiles = nil
+		if ok {
+			r.removedFiles[f] = true
+			r.logChange(f, "removed file excluded by build constraints")
+			continue
+		}
+		f.Decls, f.Comments, f.Doc, f.Name = oldDecls, oldComments, oldDoc, oldName
+	}
+}
+
+// writePkgs writes the reduced packages back to their original files.
+// Files which were removed or which were left without declarations are
+// deleted, as are those of the packages no longer imported.
+func (r *reducer) writePkgs() error {
+	for _, lp := range r.prunedPkgs {
+		for _, astFile := range lp.allFiles() {
+			fname := r.fset.Position(astFile.Pos()).Filename
+			if err := os.Remove(fname); err != nil {
+				return err
+			}
+			if *verbose {
+				fmt.Fprintf(r.logOut, "%s: removed file of package no longer imported\n", fname)
+			}
+		}
+	}
+	for _, lp := range r.pkgs {
+		nonEmpty := 0
+		for _, astFile := range lp.files {
+			if len(astFile.Decls) > 0 {
+				nonEmpty++
+			}
+		}
+		for _, astFile := range lp.files {
+			fname := r.fset.Position(astFile.Pos()).Filename
+			if r.removedFiles[astFile] {
+				if err := os.Remove(fname); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(astFile.Decls) == 0 && nonEmpty > 0 {
+				if err := os.Remove(fname); err != nil {
+					return err
+				}
+				if *verbose {
+					fmt.Fprintf(r.logOut, "%s: removed empty file\n", fname)
+				}
+				continue
+			}
+			f, err := os.Create(fname)
+			if err != nil {
+				return err
+			}
+			if err := printer.Fprint(f, r.fset, astFile); err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		for _, astFile := range lp.excluded {
+			if r.removedFiles[astFile] {
+				fname := r.fset.Position(astFile.Pos()).Filename
+				if err := os.Remove(fname); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tmpName returns the name of the file a package's file is written to
+// in the work directory.
+func (lp *localPkg) tmpName(fpath string) string {
+	return filepath.Join(lp.tdir, filepath.Base(fpath))
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+func setProcGroup(cmd *exec.Cmd) {}
+
+// killProcGroup can only kill the process itself, as process groups
+// are not supported.
+func killProcGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
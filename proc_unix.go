@@ -0,0 +1,20 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcGroup(cmd *exec.Cmd) {
+	// a negative pid signals the whole process group
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -8,19 +8,18 @@ import (
 	"context"
 	"fmt"
 	"go/ast"
-	"go/importer"
-	"go/parser"
+	"go/build"
 	"go/printer"
 	"go/token"
 	"go/types"
 	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
 
+	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -37,14 +36,33 @@ type reducer struct {
 	matchRe   *regexp.Regexp
 	shellProg *syntax.File
 
+	preds      []predicate
+	notMatchRe *regexp.Regexp
+
 	fset     *token.FileSet
 	origFset *token.FileSet
 	pkg      *ast.Package
-	files    []*ast.File
 	file     *ast.File
 
-	tconf types.Config
-	info  *types.Info
+	modRoot string
+	modPath string
+	pkgs    []*localPkg
+
+	// prunedPkgs are the packages no longer imported, to be deleted
+	prunedPkgs []*localPkg
+
+	removedFiles map[*ast.File]bool
+	dirtyFiles   map[*ast.File]bool
+
+	// otherFiles are changed along with file
+	otherFiles []*ast.File
+
+	buildCtx build.Context
+	env      []string
+
+	tconf    types.Config
+	importer types.Importer // for packages not being reduced
+	info     *types.Info
 
 	useIdents map[types.Object][]*ast.Ident
 	revDefs   map[types.Object]*ast.Ident
@@ -55,6 +73,7 @@ type reducer struct {
 	tmpFiles map[*ast.File]*os.File
 
 	tries     int
+	timeouts  int
 	didChange bool
 
 	deleteKeepUnderscore func()
@@ -62,10 +81,18 @@ type reducer struct {
 
 	tried map[string]bool
 
+	// used with -j to try changes in parallel
+	workers []*worker
+	pending []*candidate
+	results map[string]error
+
 	walker
 }
 
-var errNoReduction = fmt.Errorf("could not reduce program")
+var (
+	errNoReduction = fmt.Errorf("could not reduce program")
+	errTimedOut    = fmt.Errorf("timed out")
+)
 
 func reduce(dir, match string, logOut io.Writer, shellStr string) error {
 	r := &reducer{
@@ -73,34 +100,72 @@ func reduce(dir, match string, logOut io.Writer, shellStr string) error {
 		logOut: logOut,
 		tried:  make(map[string]bool, 16),
 		dstBuf: bytes.NewBuffer(nil),
+
+		removedFiles: make(map[*ast.File]bool),
+		dirtyFiles:   make(map[*ast.File]bool),
 	}
-	var err error
-	if r.tdir, err = ioutil.TempDir("", "goreduce"); err != nil {
+	workDir, err := ioutil.TempDir("", "goreduce")
+	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(
-		filepath.Join(r.tdir, "go.mod"),
-		[]byte("module tmp"), 0666,
-	); err != nil {
+	defer os.RemoveAll(workDir)
+	if r.matchRe, err = regexp.Compile(match); err != nil {
 		return err
 	}
-	defer os.RemoveAll(r.tdir)
-	if r.matchRe, err = regexp.Compile(match); err != nil {
+	if *hang && *timeout <= 0 {
+		return fmt.Errorf("-hang requires a -timeout")
+	}
+	if err := r.setupPredicates(match); err != nil {
 		return err
 	}
+	r.buildCtx = build.Default
+	r.env = os.Environ()
+	if *buildTags != "" {
+		r.buildCtx.BuildTags = strings.Split(*buildTags, ",")
+		// pass the tags on to the go commands run by the shell
+		for i, kv := range r.env {
+			if strings.HasPrefix(kv, "GOFLAGS=") {
+				r.env = append(r.env[:i:i], r.env[i+1:]...)
+				break
+			}
+		}
+		goflags := strings.TrimSpace(os.Getenv("GOFLAGS") + " -tags=" + *buildTags)
+		r.env = append(r.env, "GOFLAGS="+goflags)
+	}
 	r.fset = token.NewFileSet()
-	pkgs, err := parser.ParseDir(r.fset, dir, nil, parser.ParseComments)
+	r.origFset = token.NewFileSet()
+	if err := r.loadModule(dir); err != nil {
+		return err
+	}
+	path := "tmp"
+	if r.modRoot != "" {
+		if path, err = r.modImportPath(dir); err != nil {
+			return err
+		}
+	}
+	mainPkg, xtestPkg, err := r.loadPkgs(path, dir, *testName != "")
 	if err != nil {
 		return err
 	}
-	if len(pkgs) != 1 {
-		return fmt.Errorf("expected 1 package, got %d", len(pkgs))
+	r.pkg = mainPkg.pkg
+	if *deps {
+		seen := map[string]bool{path: true}
+		if err := r.loadDeps(mainPkg, seen); err != nil {
+			return err
+		}
+	}
+	r.pkgs = append(r.pkgs, mainPkg)
+	if xtestPkg != nil {
+		r.pkgs = append(r.pkgs, xtestPkg)
 	}
-	for _, pkg := range pkgs {
-		r.pkg = pkg
+	if err := r.setupWorkDir(workDir); err != nil {
+		return err
 	}
+	r.tdir = mainPkg.tdir
 	switch {
 	case shellStr != "":
+	case *testName != "":
+		shellStr = fmt.Sprintf(shellStrTest, *testName)
 	case r.pkg.Name == "main":
 		shellStr = shellStrRun
 	default:
@@ -110,60 +175,52 @@ func reduce(dir, match string, logOut io.Writer, shellStr string) error {
 	if err != nil {
 		return err
 	}
-	r.origFset = token.NewFileSet()
-	parser.ParseDir(r.origFset, dir, nil, 0)
-
-	var restoreMain func()
-	r.tmpFiles = make(map[*ast.File]*os.File, len(r.pkg.Files))
-	for fpath, file := range r.pkg.Files {
-		r.files = append(r.files, file)
-		tfname := filepath.Join(r.tdir, filepath.Base(fpath))
-		f, err := os.Create(tfname)
-		if err != nil {
-			return err
-		}
-		if err := rawPrinter.Fprint(f, r.fset, file); err != nil {
-			return err
+	r.tmpFiles = make(map[*ast.File]*os.File)
+	for _, lp := range r.pkgs {
+		for _, file := range lp.allFiles() {
+			fpath := r.fset.Position(file.Pos()).Filename
+			f, err := os.Create(lp.tmpName(fpath))
+			if err != nil {
+				return err
+			}
+			if err := rawPrinter.Fprint(f, r.fset, file); err != nil {
+				return err
+			}
+			r.tmpFiles[file] = f
+			defer f.Close()
 		}
-		r.tmpFiles[file] = f
-		defer f.Close()
 	}
-	r.tconf.Importer = importer.Default()
+	if err := r.loadExports(); err != nil {
+		return err
+	}
+	r.tconf.Importer = r
+	r.tconf.FakeImportC = true
 	r.tconf.Error = func(err error) {
-		if terr, ok := err.(types.Error); ok && terr.Soft {
-			// don't stop type-checking on soft errors
-			return
-		}
-		//panic("types.Check should not error here: " + err.Error())
+		// keep going, to fill as much of r.info as possible
+	}
+	r.newInfo()
+	// A type error is only fine if it's what we're reducing
+	if err := r.checkPkgs(); err != nil && (match == "" || !r.matchRe.MatchString(err.Error())) {
+		return fmt.Errorf("does not type-check: %v", err)
 	}
 	// Check that the output matches before we apply any changes
 	if !fastTest {
-		if err := r.checkRun(); err != nil {
+		if err := r.checkRun(context.Background(), r.tdir); err != nil {
 			return err
 		}
 	}
-	r.fillParents()
-	if anyChanges := r.reduceLoop(); !anyChanges {
-		return errNoReduction
-	}
-	if restoreMain != nil {
-		restoreMain()
-	}
-	for astFile := range r.tmpFiles {
-		astFile.Name.Name = r.pkg.Name
-		fname := r.fset.Position(astFile.Pos()).Filename
-		f, err := os.Create(fname)
+	if *jobs > 1 {
+		cleanup, err := r.setupWorkers(workDir)
+		defer cleanup()
 		if err != nil {
 			return err
 		}
-		if err := printer.Fprint(f, r.fset, astFile); err != nil {
-			return err
-		}
-		if err := f.Close(); err != nil {
-			return err
-		}
 	}
-	return nil
+	r.fillParents()
+	if anyChanges := r.reduceLoop(); !anyChanges {
+		return errNoReduction
+	}
+	return r.writePkgs()
 }
 
 func (r *reducer) logChange(node ast.Node, format string, a ...interface{}) {
@@ -179,42 +236,84 @@ func (r *reducer) logChange(node ast.Node, format string, a ...interface{}) {
 	r.tries = 0
 }
 
-func (r *reducer) checkRun() error {
-	out := r.runCmd()
-	if out == nil {
-		return fmt.Errorf("expected an error to occur")
-	}
-	if !r.matchRe.Match(out) {
-		return fmt.Errorf("error does not match:\n%s", string(out))
+// checkRun runs the shell code in dir, returning an error if the result
+// isn't interesting.
+func (r *reducer) checkRun(ctx context.Context, dir string) error {
+	res := r.runCmd(ctx, dir)
+	switch {
+	case *hang && !res.timedOut:
+		return fmt.Errorf("expected a timeout to occur")
+	case !*hang && res.timedOut:
+		return errTimedOut
 	}
-	return nil
+	return r.checkResult(res)
 }
 
 func (r *reducer) okChangeNoUndo() bool {
-	if r.didChange {
+	if r.didChange || r.batchFull() {
 		return false
 	}
-	r.dstBuf.Reset()
-	if err := rawPrinter.Fprint(r.dstBuf, r.fset, r.file); err != nil {
-		return false
+	files := append([]*ast.File{r.file}, r.otherFiles...)
+	srcs := make([][]byte, len(files))
+	var key bytes.Buffer
+	for i, file := range files {
+		r.dstBuf.Reset()
+		if err := rawPrinter.Fprint(r.dstBuf, r.fset, file); err != nil {
+			return false
+		}
+		srcs[i] = append([]byte(nil), r.dstBuf.Bytes()...)
+		key.WriteString(r.tmpFiles[file].Name())
+		key.WriteByte(0)
+		key.Write(srcs[i])
 	}
-	newSrc := r.dstBuf.String()
+	newSrc := key.String()
 	if r.tried[newSrc] {
 		return false
 	}
-	r.tries++
-	r.tried[newSrc] = true
-	f := r.tmpFiles[r.file]
-	if err := f.Truncate(0); err != nil {
-		return false
+	if r.workers != nil {
+		err, ok := r.results[newSrc]
+		if !ok {
+			r.addPending(newSrc, files, srcs)
+			return false
+		}
+		delete(r.results, newSrc)
+		return r.accepted(newSrc, err)
 	}
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return false
+	for i, file := range files {
+		if err := r.writeTmp(file, srcs[i]); err != nil {
+			return false
+		}
+		delete(r.dirtyFiles, file)
 	}
-	if _, err := f.Write(r.dstBuf.Bytes()); err != nil {
-		return false
+	for file := range r.dirtyFiles {
+		// a failed change left this file as it was
+		r.dstBuf.Reset()
+		if err := rawPrinter.Fprint(r.dstBuf, r.fset, file); err != nil {
+			return false
+		}
+		if err := r.writeTmp(file, r.dstBuf.Bytes()); err != nil {
+			return false
+		}
+		delete(r.dirtyFiles, file)
 	}
-	if err := r.checkRun(); err != nil {
+	err := r.checkRun(context.Background(), r.tdir)
+	if err != nil {
+		for _, file := range files {
+			r.dirtyFiles[file] = true
+		}
+	}
+	return r.accepted(newSrc, err)
+}
+
+// accepted records the result of trying a change, given the error from
+// checking its run, and reports whether the change worked.
+func (r *reducer) accepted(src string, err error) bool {
+	r.tries++
+	r.tried[src] = true
+	if err == errTimedOut {
+		r.timeouts++
+	}
+	if err != nil {
 		return false
 	}
 	// Reduction worked
@@ -222,6 +321,22 @@ func (r *reducer) okChangeNoUndo() bool {
 	return true
 }
 
+// writeTmp replaces the contents of a file's copy in the work directory.
+func (r *reducer) writeTmp(file *ast.File, src []byte) error {
+	f := r.tmpFiles[file]
+	if f == nil { // no longer part of the build
+		return nil
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := f.Write(src)
+	return err
+}
+
 func (r *reducer) okChange() bool {
 	if r.okChangeNoUndo() {
 		r.deleteKeepUnderscore = nil
@@ -240,21 +355,49 @@ func (r *reducer) okChange() bool {
 	return false
 }
 
+// reduceLoop applies the rules until none of them work. Then, names are
+// made shorter, which doesn't give the rules anything new to do.
 func (r *reducer) reduceLoop() (anyChanges bool) {
-	r.info = &types.Info{
-		Defs: make(map[*ast.Ident]types.Object),
-		Uses: make(map[*ast.Ident]types.Object),
-	}
+	renaming := false
 	for {
 		// Update type info after the AST changes
-		r.tconf.Check(r.tdir, r.fset, r.files, r.info)
+		r.pruneDeps()
+		r.checkPkgs()
 		r.fillObjs()
 
 		r.didChange = false
-		r.walk(r.pkg, r.reduceNode)
+		if r.workers != nil {
+			// results from before the last change are stale
+			r.results = make(map[string]error)
+		}
+		for {
+			if renaming {
+				r.renameNames()
+			} else {
+				for _, lp := range r.pkgs {
+					// one file at a time, as r.file must be the
+					// file holding the nodes being changed
+					for _, file := range lp.files {
+						r.walk(file, r.reduceNode)
+					}
+				}
+			}
+			if r.didChange || len(r.pending) == 0 {
+				break
+			}
+			// walk again once the changes found were tried
+			r.tryPending()
+		}
 		if !r.didChange {
+			if !renaming {
+				renaming = true
+				continue
+			}
 			if *verbose {
 				fmt.Fprintf(r.logOut, "gave up after %d final tries\n", r.tries)
+				if r.timeouts > 0 {
+					fmt.Fprintf(r.logOut, "rejected %d changes by timeout\n", r.timeouts)
+				}
 			}
 			return
 		}
@@ -262,6 +405,18 @@ func (r *reducer) reduceLoop() (anyChanges bool) {
 	}
 }
 
+func (r *reducer) newInfo() {
+	r.info = &types.Info{
+		Types:     make(map[ast.Expr]types.TypeAndValue),
+		Defs:      make(map[*ast.Ident]types.Object),
+		Uses:      make(map[*ast.Ident]types.Object),
+		Implicits: make(map[ast.Node]types.Object),
+		Instances: make(map[*ast.Ident]types.Instance),
+
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+}
+
 func (r *reducer) fillObjs() {
 	r.revDefs = make(map[types.Object]*ast.Ident, len(r.info.Defs))
 	for id, obj := range r.info.Defs {
@@ -272,8 +427,8 @@ func (r *reducer) fillObjs() {
 	}
 	r.useIdents = make(map[types.Object][]*ast.Ident, len(r.info.Uses)/2)
 	for id, obj := range r.info.Uses {
-		if pkg := obj.Pkg(); pkg == nil || pkg.Name() != r.pkg.Name {
-			// builtin or declared outside of our pkg
+		if !r.isLocal(obj.Pkg()) {
+			// builtin or declared outside of our pkgs
 			continue
 		}
 		r.useIdents[obj] = append(r.useIdents[obj], id)
@@ -282,8 +437,16 @@ func (r *reducer) fillObjs() {
 
 func (r *reducer) fillParents() {
 	r.parents = make(map[ast.Node]ast.Node)
-	stack := make([]ast.Node, 1, 32)
-	ast.Inspect(r.pkg, func(node ast.Node) bool {
+	for _, lp := range r.pkgs {
+		r.setParents(lp.pkg, nil)
+	}
+}
+
+// setParents records the parents of node and all of its descendants,
+// for nodes which were added to the AST.
+func (r *reducer) setParents(node, parent ast.Node) {
+	stack := append(make([]ast.Node, 0, 32), parent)
+	ast.Inspect(node, func(node ast.Node) bool {
 		if node == nil {
 			stack = stack[:len(stack)-1]
 			return true
@@ -294,14 +457,44 @@ func (r *reducer) fillParents() {
 	})
 }
 
-func (r *reducer) runCmd() []byte {
-	var buf bytes.Buffer
-	runner, err := interp.New(interp.Dir(r.tdir), interp.StdIO(nil, &buf, &buf))
+// runCmd runs the shell code in dir, returning its output and exit status, and
+// whether a command was killed for running longer than -timeout. It is also
+// killed if ctx is cancelled.
+func (r *reducer) runCmd(ctx context.Context, dir string) *runResult {
+	res := &runResult{}
+	stdout, stderr, done := outWriters(res)
+	execTimeout := func(ctx context.Context, path string, args []string) error {
+		if *timeout <= 0 || buildOnly(args) {
+			return execGroup(ctx, path, args)
+		}
+		ctx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		err := execGroup(ctx, path, args)
+		if ctx.Err() == context.DeadlineExceeded {
+			res.timedOut = true
+		}
+		return err
+	}
+	runner, err := interp.New(
+		interp.Env(expand.ListEnviron(r.env...)),
+		interp.Dir(dir),
+		interp.StdIO(nil, stdout, stderr),
+		interp.Module(interp.ModuleExec(execTimeout)),
+	)
 	if err != nil {
 		panic(err)
 	}
-	runner.Run(context.TODO(), r.shellProg)
-	return buf.Bytes()
+	switch x := runner.Run(ctx, r.shellProg).(type) {
+	case nil:
+	case interp.ExitStatus:
+		res.exit = int(x)
+	case interp.ShellExitStatus:
+		res.exit = int(x)
+	default:
+		res.exit = 1
+	}
+	done()
+	return res
 }
 
 func (r *reducer) exprRef(expr ast.Expr) *ast.Expr {
@@ -6,9 +6,11 @@ package main
 import (
 	"bytes"
 	"flag"
+	"go/ast"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -16,6 +18,8 @@ import (
 var (
 	write = flag.Bool("w", false, "write test outputs")
 	fast  = flag.Bool("f", false, "skip work to make tests faster")
+
+	timeoutsRe = regexp.MustCompile(`^rejected \d+ changes by timeout$`)
 )
 
 func TestMain(m *testing.M) {
@@ -51,36 +55,113 @@ func writeFile(t testing.TB, dir, path, cont string) {
 	}
 }
 
+// setFlags sets the command line flags listed in a test's flags file,
+// returning a func to restore their previous values.
+func setFlags(t testing.TB, args []string) (restore func()) {
+	prev := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		prev[f.Name] = f.Value.String()
+	})
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		for name, value := range prev {
+			flag.Set(name, value)
+		}
+	}
+}
+
+// copyDir copies a test's directory into a temporary one, so that it
+// is reduced on its own rather than as part of this module.
+func copyDir(t testing.TB, dir string) string {
+	tdir := t.TempDir()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(tdir, rel), 0755)
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(tdir, rel), bs, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tdir
+}
+
+// goFiles returns the Go files within a test's directory, relative to
+// it.
+func goFiles(t testing.TB, dir string) []string {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".go") {
+			rel, _ := filepath.Rel(dir, path)
+			paths = append(paths, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
 func testReduction(name string) func(*testing.T) {
 	return func(t *testing.T) {
-		t.Parallel()
 		dir := filepath.Join("testdata", name)
-		orig := []byte(readFile(t, dir, "src.go"))
-		defer ioutil.WriteFile(filepath.Join(dir, "src.go"), orig, 0644)
-		want := readFile(t, dir, "src.go.min")
+		if _, err := os.Stat(filepath.Join(dir, "flags")); err == nil {
+			// flags are global, so these tests can't be parallel
+			args := strings.Fields(readFile(t, dir, "flags"))
+			defer setFlags(t, args)()
+		} else {
+			t.Parallel()
+		}
+		paths := goFiles(t, dir)
 		match := strings.TrimRight(readFile(t, dir, "match"), "\n")
-		impPath := "./testdata/" + name
+		tdir := copyDir(t, dir)
 		var buf bytes.Buffer
-		if err := reduce(impPath, match, &buf, ""); err != nil {
+		if err := reduce(tdir, match, &buf, ""); err != nil {
 			t.Fatal(err)
 		}
-		got := readFile(t, dir, "src.go")
-		if want != got {
-			if *write {
-				writeFile(t, dir, "src.go.min", got)
-			} else {
-				t.Fatalf("unexpected program output\nwant:\n%sgot:\n%s",
-					want, got)
+		for _, path := range paths {
+			if _, err := os.Stat(filepath.Join(dir, path+".min")); err != nil {
+				continue
+			}
+			want := readFile(t, dir, path+".min")
+			// an empty output means that the file is deleted
+			got := ""
+			if _, err := os.Stat(filepath.Join(tdir, path)); err == nil {
+				got = readFile(t, tdir, path)
+			}
+			if want != got {
+				if *write {
+					writeFile(t, dir, path+".min", got)
+				} else {
+					t.Fatalf("unexpected %s output\nwant:\n%sgot:\n%s",
+						path, want, got)
+				}
 			}
 		}
-		// remove testdata/<dir>/ bit
+		// remove the temporary dir bit
 		rawLog := buf.String()
 		buf.Reset()
 		for _, line := range strings.Split(rawLog, "\n") {
 			if line == "" {
 				break
 			}
-			line = strings.TrimPrefix(line, dir+string(filepath.Separator))
+			line = strings.TrimPrefix(line, tdir+string(filepath.Separator))
+			// how many changes time out depends on the machine
+			line = timeoutsRe.ReplaceAllString(line, "rejected N changes by timeout")
 			buf.WriteString(line)
 			buf.WriteByte('\n')
 		}
@@ -132,17 +213,94 @@ func TestReduceErrs(t *testing.T) {
 	defer func() { fastTest = *fast }()
 	tests := [...]struct {
 		dir, match string
+		flags      []string
 		errCont    string
 	}{
-		{"missing-dir", "[", "missing closing ]"},
-		{"missing-dir", ".", "no such file"},
-		{"testdata/remove-stmt", "no-match", "does not match"},
+		{"missing-dir", "[", nil, "missing closing ]"},
+		{"missing-dir", ".", nil, "no such file"},
+		{"testdata/remove-stmt", "no-match", nil, "does not match"},
+		{"testdata/remove-stmt", "", nil, "nothing to match"},
+		{"testdata/compile-crash", "no-match", nil, "does not type-check"},
+		{"testdata/compile-crash", "", []string{"-exit=1"}, "does not type-check"},
+		{"testdata/hang", "", []string{"-hang", "-timeout=1s", "-exit=1"}, "cannot be used with -hang"},
 	}
 	for _, tc := range tests {
-		err := reduce(tc.dir, tc.match, ioutil.Discard, "")
+		dir := tc.dir
+		if _, err := os.Stat(dir); err == nil {
+			dir = copyDir(t, dir)
+		}
+		restore := setFlags(t, tc.flags)
+		err := reduce(dir, tc.match, ioutil.Discard, "")
+		restore()
 		if err == nil || !strings.Contains(err.Error(), tc.errCont) {
 			t.Fatalf("wanted error conatining %q, got: %v",
 				tc.errCont, err)
 		}
 	}
 }
+
+func TestUnreplaceStmts(t *testing.T) {
+	t.Parallel()
+	stmts := make(map[string]ast.Stmt)
+	for _, name := range []string{"a", "b", "c", "d", "x", "y"} {
+		stmts[name] = &ast.ExprStmt{X: ast.NewIdent(name)}
+	}
+	list := func(names string) []ast.Stmt {
+		var l []ast.Stmt
+		for _, name := range strings.Fields(names) {
+			l = append(l, stmts[name])
+		}
+		return l
+	}
+	names := func(l []ast.Stmt) string {
+		var s []string
+		for _, stmt := range l {
+			s = append(s, stmt.(*ast.ExprStmt).X.(*ast.Ident).Name)
+		}
+		return strings.Join(s, " ")
+	}
+	tests := [...]struct {
+		list, orig string
+		i          int
+		with       string
+		want       string
+	}{
+		{"a c", "a b c", 1, "", "a b c"},
+		{"a x y c", "a b c", 1, "x y", "a b c"},
+		{"b c", "a b c", 0, "", "a b c"},
+		{"a b", "a b c", 2, "", "a b c"},
+		// undone out of order: c was removed after b
+		{"a", "a b c", 1, "", "a b"},
+		{"a b", "a c", 1, "", "a b c"},
+		// the statement before is gone, but not the one after
+		{"c d", "a b c d", 1, "", "b c d"},
+		// neither neighbour is left
+		{"d", "a b c", 1, "", "d"},
+		{"d", "a x c", 1, "x", "d"},
+	}
+	for _, tc := range tests {
+		got := names(unreplaceStmts(list(tc.list), list(tc.orig), tc.i, list(tc.with)))
+		if got != tc.want {
+			t.Errorf("unreplaceStmts(%q, %q, %d, %q) = %q, want %q",
+				tc.list, tc.orig, tc.i, tc.with, got, tc.want)
+		}
+	}
+}
+
+func TestFindModule(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	writeFile(t, root, "go.mod", "module example.com/m\n")
+	dir := filepath.Join(root, "pkg", "testdata", "crasher")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// like the go tool, testdata dirs use the enclosing module
+	got, err := findModule(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root {
+		t.Fatalf("findModule(%q) = %q, want %q", dir, got, root)
+	}
+}
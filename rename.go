@@ -0,0 +1,211 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// The short names given to funcs, types and any other objects when
+// renaming them, in order. Once a list runs out, its first name is used
+// with a number, as in f1.
+var (
+	funcNames  = []string{"f", "g", "h"}
+	typeNames  = []string{"T", "U", "V"}
+	otherNames = []string{"x", "y", "z"}
+)
+
+func shortNames(obj types.Object) []string {
+	switch obj.(type) {
+	case *types.Func:
+		return funcNames
+	case *types.TypeName:
+		return typeNames
+	}
+	return otherNames
+}
+
+// shortName returns the i-th name that renaming picks from a list.
+func shortName(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return names[0] + strconv.Itoa(i-len(names)+1)
+}
+
+// isShortName reports whether a name is one that renaming could have
+// picked from a list, so that it's left alone.
+func isShortName(name string, names []string) bool {
+	for _, short := range names {
+		if name == short {
+			return true
+		}
+	}
+	if !strings.HasPrefix(name, names[0]) {
+		return false
+	}
+	n, err := strconv.Atoi(name[len(names[0]):])
+	return err == nil && n > 0 && names[0]+strconv.Itoa(n) == name
+}
+
+// rename is a planned change of name for an object.
+type rename struct {
+	obj  types.Object
+	id   *ast.Ident // where obj is declared
+	name string
+}
+
+// renamable reports whether the object declared by id may be given a
+// short name. Exported names are only renamed where they could also be
+// removed. Methods are left alone, as they may implement interfaces, as
+// are embedded fields and the types they embed, whose names are tied.
+func (r *reducer) renamable(id *ast.Ident, obj types.Object) bool {
+	if obj == nil || id.Name == "_" || !r.removableName(id) {
+		return false
+	}
+	if isShortName(id.Name, shortNames(obj)) {
+		return false
+	}
+	switch x := obj.(type) {
+	case *types.Var:
+		return !x.Anonymous()
+	case *types.Const:
+		return true
+	case *types.Func:
+		if x.Type().(*types.Signature).Recv() != nil {
+			return false
+		}
+		switch x.Name() {
+		case "main", "init", *testName:
+			return false
+		}
+		return true
+	case *types.TypeName:
+		for _, use := range r.useIdents[obj] {
+			if r.info.Defs[use] != nil { // struct{ T }
+				return false
+			}
+		}
+		return true
+	}
+	return false // e.g. labels
+}
+
+// renameCandidates returns the objects declared in a package which may
+// be given short names, along with the names they would get.
+//
+// Names at the package level, as well as field names, must not clash
+// with any other name used in the package. Local names only need to
+// avoid those used in their top-level declaration, and the new names at
+// the package level. Since no two new names clash, any subset of the
+// renames may be applied.
+func (r *reducer) renameCandidates(lp *localPkg) []rename {
+	allNames := make(map[string]bool)
+	declNames := make(map[ast.Decl]map[string]bool)
+	var global, local []rename
+	var localDecls []ast.Decl
+	for _, f := range lp.files {
+		r.file = f // for removableName
+		for _, decl := range f.Decls {
+			names := make(map[string]bool)
+			declNames[decl] = names
+			ast.Inspect(decl, func(node ast.Node) bool {
+				id, _ := node.(*ast.Ident)
+				if id == nil {
+					return true
+				}
+				allNames[id.Name] = true
+				names[id.Name] = true
+				obj := r.info.Defs[id]
+				if !r.renamable(id, obj) {
+					return true
+				}
+				rn := rename{obj: obj, id: id}
+				if scope := obj.Parent(); scope == nil || scope == lp.types.Scope() {
+					global = append(global, rn) // or a field
+				} else {
+					local = append(local, rn)
+					localDecls = append(localDecls, decl)
+				}
+				return true
+			})
+		}
+	}
+	globalNames := make(map[string]bool)
+	for i := range global {
+		names := shortNames(global[i].obj)
+		for j := 0; ; j++ {
+			name := shortName(names, j)
+			if !allNames[name] {
+				global[i].name = name
+				allNames[name] = true
+				globalNames[name] = true
+				break
+			}
+		}
+	}
+	for i := range local {
+		names := shortNames(local[i].obj)
+		taken := declNames[localDecls[i]]
+		for j := 0; ; j++ {
+			name := shortName(names, j)
+			if !taken[name] && !globalNames[name] {
+				local[i].name = name
+				taken[name] = true
+				break
+			}
+		}
+	}
+	return append(global, local...)
+}
+
+// renameNames tries to give short names to the objects declared in the
+// packages, all at once first and then in smaller batches, as the output
+// might depend on some of the names.
+func (r *reducer) renameNames() {
+	for _, lp := range r.pkgs {
+		rns := r.renameCandidates(lp)
+		if len(rns) == 0 {
+			continue
+		}
+		if r.renamed(rns) {
+			return
+		}
+		if chunks(len(rns), 1, func(start, end int) bool {
+			return r.renamed(rns[start:end])
+		}) {
+			return
+		}
+	}
+}
+
+// renamed tries to apply a batch of renames to the declarations and all
+// the uses of their objects.
+func (r *reducer) renamed(rns []rename) bool {
+	var nodes []ast.Node
+	var undos []func()
+	for _, rn := range rns {
+		ids := append([]*ast.Ident{rn.id}, r.useIdents[rn.obj]...)
+		for _, id := range ids {
+			id, name := id, id.Name
+			id.Name = rn.name
+			nodes = append(nodes, id)
+			undos = append(undos, func() { id.Name = name })
+		}
+	}
+	oldName := rns[0].obj.Name()
+	r.file = r.nodeFile(rns[0].id)
+	if !r.tryEdits(nodes, nil, undos) {
+		return false
+	}
+	if len(rns) == 1 {
+		r.logChange(rns[0].id, "%s -> %s", oldName, rns[0].name)
+	} else {
+		r.logChange(rns[0].id, "%d names renamed", len(rns))
+	}
+	return true
+}
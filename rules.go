@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"strconv"
@@ -15,7 +16,7 @@ import (
 
 // uses interface{} instead of ast.Node for node slices
 func (r *reducer) reduceNode(v interface{}) bool {
-	if r.didChange {
+	if r.didChange || r.batchFull() {
 		return false
 	}
 	if expr, ok := v.(ast.Expr); ok {
@@ -24,24 +25,44 @@ func (r *reducer) reduceNode(v interface{}) bool {
 		case nil: // not possible
 		case expr: // same
 		default:
+			if _, ok := rsExpr.(*ast.CompositeLit); !ok {
+				// e.g. len(arr) or unsafe.Sizeof(x)
+				r.afterDelete(expr)
+			}
 			if r.changedExpr(expr, rsExpr) {
+				r.setParents(rsExpr, r.parents[rsExpr])
 				r.logChange(expr, "resolved expression")
 				return true
 			}
 		}
+		if r.zeroExpr(expr) {
+			return false
+		}
 	}
 	switch x := v.(type) {
 	case *ast.File:
 		r.file = x
 		// put the original src for the file in the tried map
+		r.dstBuf.Reset()
 		if err := rawPrinter.Fprint(r.dstBuf, r.fset, r.file); err != nil {
 			return false
 		}
-		newSrc := r.dstBuf.String()
+		newSrc := r.tmpFiles[x].Name() + "\x00" + r.dstBuf.String()
 		r.tried[newSrc] = true
+		r.removeFile(x)
+		if lp := r.filePkg(x); lp != nil && lp.files[0] == x {
+			r.removeExcluded(lp)
+		}
+		if *testName != "" {
+			r.removeOtherTests()
+			if r.inlineTestPkg() {
+				r.logChange(x, "test package inlined")
+			}
+		}
+		r.removeDeclChunks(x)
 	case *ast.ValueSpec:
 		for _, name := range x.Names {
-			if ast.IsExported(name.Name) {
+			if !r.removableName(name) {
 				return true
 			}
 			if len(r.useIdents[r.info.Defs[name]]) > 0 {
@@ -49,6 +70,7 @@ func (r *reducer) reduceNode(v interface{}) bool {
 			}
 		}
 		undo := r.removeSpec(x)
+		r.afterDelete(x)
 		if r.okChange() {
 			r.mergeLines(x.Pos(), x.End()+1)
 			gd := r.parents[x].(*ast.GenDecl)
@@ -61,6 +83,10 @@ func (r *reducer) reduceNode(v interface{}) bool {
 			undo()
 		}
 	case *ast.ImportSpec:
+		if r.flattenImport(x) {
+			r.logChange(x, "package inlined")
+			return false
+		}
 		if x.Name == nil || x.Name.Name != "_" { // used
 			return false
 		}
@@ -75,7 +101,12 @@ func (r *reducer) reduceNode(v interface{}) bool {
 		if len(*x) == 1 { // we already tried removing the parent
 			break
 		}
-		r.removeStmt(x)
+		if r.removeRecoverDefer(x) {
+			return false
+		}
+		if !r.removeStmtChunks(x) {
+			r.removeStmt(x)
+		}
 	case *ast.BlockStmt:
 		if r.parentStmts(x) != nil {
 			undo := r.adaptBlockNames(x)
@@ -104,22 +135,40 @@ func (r *reducer) reduceNode(v interface{}) bool {
 				break
 			}
 		}
+	case *ast.ForStmt, *ast.RangeStmt:
+		if r.reduceLoopStmt(x.(ast.Stmt)) {
+			return false
+		}
+	case *ast.BranchStmt:
+		if r.removeBranch(x) {
+			return false
+		}
 	case *ast.SwitchStmt:
-		if x.Init != nil || len(x.Body.List) != 1 {
-			break
+		if r.reduceSwitch(x) {
+			return false
 		}
-		cs := x.Body.List[0].(*ast.CaseClause)
-		if r.replacedStmts(x, cs.Body) {
-			r.logChange(cs, "case inlined")
+	case *ast.TypeSwitchStmt:
+		if r.typeSwitchCase(x) {
+			return false
 		}
+	case *ast.CaseClause:
+		r.removeCaseExpr(x)
 	case *ast.Ident:
 		obj := r.info.Uses[x]
 		if obj == nil { // declaration of ident, not its use
+			if r.inlineFuncVar(x) {
+				r.logChange(x, "func var inlined")
+				return false
+			}
 			break
 		}
 		if len(r.useIdents[obj]) > 1 { // used elsewhere
 			break
 		}
+		if _, ok := r.parents[x].(*ast.SelectorExpr); ok {
+			// declared in another package
+			break
+		}
 		if _, ok := obj.Type().(*types.Basic); !ok {
 			break
 		}
@@ -159,6 +208,7 @@ func (r *reducer) reduceNode(v interface{}) bool {
 			break
 		}
 		x.Elts = orig
+		r.removeEltChunks(x)
 	case *ast.BinaryExpr:
 		r.afterDelete(x.Y)
 		if r.changedExpr(x, x.X) {
@@ -176,9 +226,75 @@ func (r *reducer) reduceNode(v interface{}) bool {
 			r.logChange(x, "a[b] -> a")
 			break
 		}
+		r.reduceTypeArgs(x, []*ast.Expr{&x.Index})
+	case *ast.IndexListExpr:
+		r.afterDeleteExprs(x.Indices)
+		if r.changedExpr(x, x.X) {
+			r.logChange(x, "a[b, c] -> a")
+			break
+		}
+		args := make([]*ast.Expr, len(x.Indices))
+		for i := range x.Indices {
+			args[i] = &x.Indices[i]
+		}
+		r.reduceTypeArgs(x, args)
+	case *ast.Field:
+		if r.reduceField(x) {
+			return false
+		}
+	case *ast.FieldList:
+		r.reduceTypeParams(x)
+	case *ast.CallExpr:
+		if r.removeConversion(x) {
+			return false
+		}
+		if r.instantiateCall(x) {
+			r.logChange(x, "generic func instantiated")
+			break
+		}
+		if r.inlineCallExpr(x) {
+			r.logChange(x, "inlined call result")
+			return false
+		}
+		if r.callToClosure(x) {
+			r.logChange(x, "func call -> closure call")
+			break
+		}
+		if r.removeSyncCalls(x) {
+			return false
+		}
+		r.reduceChanBuffer(x)
 	case *ast.StarExpr:
 		if r.changedExpr(x, x.X) {
 			r.logChange(x, "*a -> a")
+			break
+		}
+		if r.removePointerType(x) {
+			return false
+		}
+	case *ast.ArrayType:
+		if r.shrinkArrayLen(x) {
+			return false
+		}
+	case *ast.SelectStmt:
+		if r.selectCase(x) {
+			return false
+		}
+	case *ast.SendStmt:
+		if r.removeSend(x) {
+			r.logChange(x, "ch <- a() -> a()")
+		}
+	case *ast.AssignStmt:
+		if r.removeRecv(x) {
+			r.logChange(x, "v := <-ch -> var v T")
+			return false
+		}
+		if r.zeroAssign(x) {
+			return false
+		}
+		if r.inlineLitAssign(x) {
+			r.logChange(x, "inlined func literal")
+			return false
 		}
 	case *ast.GoStmt:
 		if r.changedStmt(x, &ast.ExprStmt{X: x.Call}) {
@@ -193,6 +309,14 @@ func (r *reducer) reduceNode(v interface{}) bool {
 		if ce == nil {
 			break
 		}
+		if r.inlineCallStmt(x, ce) {
+			r.logChange(x, "inlined call with args")
+			return false
+		}
+		if r.inlineLitStmt(x, ce) {
+			r.logChange(x, "inlined func literal")
+			return false
+		}
 		ftype, fbody := r.funcDetails(ce.Fun)
 		if fbody == nil || anyFuncControlNodes(fbody) {
 			break
@@ -207,7 +331,28 @@ func (r *reducer) reduceNode(v interface{}) bool {
 		if r.changedStmt(x, fbody) {
 			r.logChange(x, "inlined call")
 		}
+	case *ast.FuncLit:
+		if ce, _ := r.parents[x].(*ast.CallExpr); ce != nil && ce.Fun == x {
+			break // left to the rules inlining it
+		}
+		if r.hoistLit(x) {
+			r.logChange(x, "func literal hoisted")
+			return false
+		}
+	case *ast.TypeSpec:
+		if r.removeTypeSpec(x) || r.inlineTypeSpec(x) {
+			return false
+		}
 	case *ast.FuncDecl:
+		if r.removeFuncDecl(x) {
+			return false
+		}
+		if r.reduceFuncSignature(x) {
+			break
+		}
+		if r.methodToFunc(x) {
+			return false
+		}
 		if x.Recv == nil || len(x.Recv.List) != 1 {
 			break
 		}
@@ -242,212 +387,249 @@ func (r *reducer) reduceNode(v interface{}) bool {
 	return true
 }
 
-// resolveExpr will try to resolve a constant expression, returning an
-// *ast.BasicLit or *ast.CompositeLit if it succeeds. If it did not, it
-// will return nil.
+// resolveExpr will try to resolve a constant expression, returning a
+// literal or an *ast.CompositeLit if it succeeds. If it did not, it will
+// return nil. Expressions that go/types knows the value of are resolved
+// by resolveConst; the rest, such as indexing a composite literal, are
+// resolved here.
 func (r *reducer) resolveExpr(e ast.Expr) ast.Expr {
+	if bl, ok := e.(*ast.BasicLit); ok {
+		return bl
+	}
+	if tv := r.info.Types[e]; tv.Value != nil {
+		return r.resolveConst(e)
+	}
+	if val := r.resolveValue(e); val != nil {
+		return r.valueLit(val, r.info.TypeOf(e), true, e.Pos())
+	}
+	if cl := r.resolveComposite(e); cl != nil {
+		return cl
+	}
+	if ie, _ := e.(*ast.IndexExpr); ie != nil {
+		cl := r.resolveComposite(ie.X)
+		i, ok := r.resolveInt(ie.Index)
+		if cl == nil || !ok || i < 0 || i >= len(cl.Elts) {
+			return nil
+		}
+		elt, _ := cl.Elts[i].(*ast.CompositeLit)
+		if elt != nil && elt.Type != nil && types.Identical(r.info.TypeOf(elt), r.info.TypeOf(e)) {
+			return elt
+		}
+	}
+	return nil
+}
+
+// resolveValue returns the value of an expression which isn't constant,
+// but whose value is known, such as "foo"[0] or len([]int{1, 2}). It
+// returns nil if the value isn't known.
+func (r *reducer) resolveValue(e ast.Expr) constant.Value {
+	tv, ok := r.info.Types[e]
+	if !ok {
+		return nil
+	}
+	if tv.Value != nil {
+		return tv.Value
+	}
+	var val constant.Value
 	switch x := e.(type) {
-	case *ast.BasicLit:
-		return x
 	case *ast.ParenExpr:
-		return r.resolveExpr(x.X)
-	case *ast.CompositeLit:
-		cl := *x
-		for i, expr := range cl.Elts {
-			rsExpr := r.resolveExpr(expr)
-			if rsExpr == nil {
-				return nil
-			}
-			cl.Elts[i] = rsExpr
-		}
-		return &cl
+		val = r.resolveValue(x.X)
 	case *ast.UnaryExpr:
-		bl1, _ := r.resolveExpr(x.X).(*ast.BasicLit)
-		if bl1 == nil {
-			break
-		}
-		bl := *bl1
-		switch bl1.Kind {
-		case token.INT:
-			a, _ := strconv.Atoi(bl1.Value)
-			var r int
-			switch x.Op {
-			case token.ADD:
-				r = a
-			case token.SUB:
-				r = -a
-			default:
-				return nil
-			}
-			bl.Value = strconv.Itoa(r)
-			return &bl
+		if y := r.resolveValue(x.X); y != nil {
+			val = unaryOp(x.Op, y, tv.Type)
 		}
 	case *ast.BinaryExpr:
-		bl1, _ := r.resolveExpr(x.X).(*ast.BasicLit)
-		bl2, _ := r.resolveExpr(x.Y).(*ast.BasicLit)
-		if bl1 == nil || bl2 == nil {
-			break
-		}
-		if bl1.Kind != bl2.Kind {
-			// we might want to treat these at some point.
-			break
-		}
-		bl := *bl1
-		switch bl1.Kind {
-		case token.INT:
-			a, _ := strconv.Atoi(bl1.Value)
-			b, _ := strconv.Atoi(bl2.Value)
-			var r int
-			switch x.Op {
-			case token.ADD:
-				r = a + b
-			case token.SUB:
-				r = a - b
-			case token.MUL:
-				r = a * b
-			case token.QUO:
-				r = a / b
-			default:
-				return nil
-			}
-			bl.Value = strconv.Itoa(r)
-			return &bl
-		case token.STRING: // x.Op == token.ADD
-			a, _ := strconv.Unquote(bl1.Value)
-			b, _ := strconv.Unquote(bl2.Value)
-			bl.Value = strconv.Quote(a + b)
-			return &bl
+		y1, y2 := r.resolveValue(x.X), r.resolveValue(x.Y)
+		if y1 != nil && y2 != nil {
+			val = binaryOp(y1, x.Op, y2)
 		}
 	case *ast.IndexExpr:
-		bli, _ := r.resolveExpr(x.Index).(*ast.BasicLit)
-		if bli == nil {
+		i, ok := r.resolveInt(x.Index)
+		if !ok || i < 0 {
 			break
 		}
-		i, _ := strconv.Atoi(bli.Value)
-		switch x := r.resolveExpr(x.X).(type) {
-		case *ast.BasicLit:
-			bl := *x // bl.Kind == token.STRING
-			if bl.Kind != token.STRING {
-				break
-			}
-			s, _ := strconv.Unquote(x.Value)
+		if s, ok := r.resolveString(x.X); ok {
 			if i < len(s) {
-				bl.Kind = token.CHAR
-				bl.Value = strconv.QuoteRune(rune(s[i]))
-				return &bl
-			}
-		case *ast.CompositeLit:
-			if i < len(x.Elts) {
-				return x.Elts[i]
+				val = constant.MakeInt64(int64(s[i]))
 			}
+		} else if cl := r.resolveComposite(x.X); cl != nil && i < len(cl.Elts) {
+			val = r.resolveValue(cl.Elts[i])
 		}
 	case *ast.SliceExpr:
-		if x.Max != nil {
+		s, ok := r.resolveString(x.X)
+		if !ok || x.Max != nil {
 			break
 		}
-		low, high := -1, -1
-		if bl, _ := r.resolveExpr(x.Low).(*ast.BasicLit); bl != nil {
-			low, _ = strconv.Atoi(bl.Value)
+		low, high, ok := r.resolveBounds(x, len(s))
+		if ok {
+			val = constant.MakeString(s[low:high])
 		}
-		if bl, _ := r.resolveExpr(x.High).(*ast.BasicLit); bl != nil {
-			high, _ = strconv.Atoi(bl.Value)
+	case *ast.CallExpr:
+		id, _ := x.Fun.(*ast.Ident)
+		if id == nil || len(x.Args) != 1 {
+			break
 		}
-		switch x := r.resolveExpr(x.X).(type) {
-		case *ast.BasicLit:
-			bl := *x // bl.Kind == token.STRING
-			if bl.Kind != token.STRING {
-				break
-			}
-			s, _ := strconv.Unquote(x.Value)
-			if high > len(s) || low > len(s) {
-				break // invalid expr
-			}
-			if high >= 0 {
-				s = s[:high]
-			}
-			if low >= 0 {
-				s = s[low:]
-			}
-			bl.Value = strconv.Quote(s)
-			return &bl
-		case *ast.CompositeLit:
-			cl := *x
-			if high > len(cl.Elts) || low > len(cl.Elts) {
-				break // invalid expr
-			}
-			if high >= 0 {
-				cl.Elts = cl.Elts[:high]
+		if bt, _ := r.info.Uses[id].(*types.Builtin); bt == nil || bt.Name() != "len" {
+			break
+		}
+		if s, ok := r.resolveString(x.Args[0]); ok {
+			val = constant.MakeInt64(int64(len(s)))
+		} else if cl := r.resolveComposite(x.Args[0]); cl != nil {
+			val = constant.MakeInt64(int64(len(cl.Elts)))
+		}
+	}
+	if val == nil || !representable(val, tv.Type) {
+		return nil
+	}
+	return val
+}
+
+// resolveInt returns the value of an expression if it's a known int.
+func (r *reducer) resolveInt(e ast.Expr) (int, bool) {
+	if e == nil {
+		return 0, false
+	}
+	val := r.resolveValue(e)
+	if val == nil || val.Kind() != constant.Int {
+		return 0, false
+	}
+	n, ok := constant.Int64Val(val)
+	return int(n), ok
+}
+
+// resolveString returns the value of an expression if it's a known
+// string.
+func (r *reducer) resolveString(e ast.Expr) (string, bool) {
+	val := r.resolveValue(e)
+	if val == nil || val.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(val), true
+}
+
+// resolveBounds returns the low and high indexes of a slice expression
+// on a value of length n, or false if they aren't known or valid.
+func (r *reducer) resolveBounds(se *ast.SliceExpr, n int) (low, high int, ok bool) {
+	low, high = 0, n
+	if se.Low != nil {
+		if low, ok = r.resolveInt(se.Low); !ok {
+			return 0, 0, false
+		}
+	}
+	if se.High != nil {
+		if high, ok = r.resolveInt(se.High); !ok {
+			return 0, 0, false
+		}
+	}
+	return low, high, 0 <= low && low <= high && high <= n
+}
+
+// resolveComposite returns a slice or array composite literal with the
+// elements that an expression evaluates to, such as []int{1, 2, 3}[1:].
+// It returns nil if they aren't known, including when any element isn't
+// known, as dropping it could drop a call with side effects.
+func (r *reducer) resolveComposite(e ast.Expr) *ast.CompositeLit {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		return r.resolveComposite(x.X)
+	case *ast.CompositeLit:
+		switch r.info.TypeOf(x).Underlying().(type) {
+		case *types.Slice, *types.Array:
+		default:
+			return nil
+		}
+		for _, elt := range x.Elts {
+			if _, ok := elt.(*ast.KeyValueExpr); ok {
+				return nil // elements may be out of order
 			}
-			if low >= 0 {
-				cl.Elts = cl.Elts[low:]
+			if !r.knownElt(elt) {
+				return nil
 			}
-			return &cl
 		}
+		cl := *x
+		cl.Elts = append([]ast.Expr(nil), x.Elts...)
+		return &cl
+	case *ast.SliceExpr:
+		if x.Max != nil {
+			break
+		}
+		if _, ok := r.info.TypeOf(x.X).Underlying().(*types.Slice); !ok {
+			break
+		}
+		cl := r.resolveComposite(x.X)
+		if cl == nil {
+			break
+		}
+		low, high, ok := r.resolveBounds(x, len(cl.Elts))
+		if !ok {
+			break
+		}
+		cl.Elts = cl.Elts[low:high]
+		return cl
 	case *ast.CallExpr:
 		id, _ := x.Fun.(*ast.Ident)
-		if id == nil || len(x.Args) == 0 {
+		if id == nil || len(x.Args) == 0 || x.Ellipsis.IsValid() {
 			break
 		}
-		bt, _ := r.info.Uses[id].(*types.Builtin)
-		if bt == nil {
+		if bt, _ := r.info.Uses[id].(*types.Builtin); bt == nil || bt.Name() != "append" {
 			break
 		}
-		args := make([]ast.Expr, len(x.Args))
-		for i, expr := range x.Args {
-			rsExpr := r.resolveExpr(expr)
-			if rsExpr == nil {
-				return nil
-			}
-			args[i] = rsExpr
+		cl := r.resolveComposite(x.Args[0])
+		if cl == nil {
+			break
 		}
-		switch bt.Name() {
-		case "append":
-			y, _ := args[0].(*ast.CompositeLit)
-			if y == nil {
-				break
-			}
-			cl := *y
-			cl.Elts = append(cl.Elts, args[1:]...)
-			return &cl
-		case "len":
-			var l int
-			switch y := args[0].(type) {
-			case *ast.BasicLit:
-				if y.Kind != token.STRING {
-					return nil
-				}
-				s, _ := strconv.Unquote(y.Value)
-				l = len(s)
-			case *ast.CompositeLit:
-				l = len(y.Elts)
-			default:
+		for _, arg := range x.Args[1:] {
+			if !r.knownElt(arg) {
 				return nil
 			}
-			return &ast.BasicLit{
-				ValuePos: x.Pos(),
-				Kind:     token.INT,
-				Value:    strconv.Itoa(l),
-			}
 		}
+		cl.Elts = append(cl.Elts, x.Args[1:]...)
+		return cl
 	}
 	return nil
 }
 
+// knownElt reports whether the value of a composite literal element is
+// known, either directly or as a composite literal made of known values.
+func (r *reducer) knownElt(e ast.Expr) bool {
+	if r.resolveValue(e) != nil {
+		return true
+	}
+	cl, _ := e.(*ast.CompositeLit)
+	if cl == nil || r.info.TypeOf(cl) == nil {
+		return false
+	}
+	_, isStruct := r.info.TypeOf(cl).Underlying().(*types.Struct)
+	for _, elt := range cl.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if !isStruct && !r.knownElt(kv.Key) {
+				return false
+			}
+			elt = kv.Value
+		}
+		if !r.knownElt(elt) {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *reducer) funcDetails(fun ast.Expr) (*ast.FuncType, *ast.BlockStmt) {
 	switch x := fun.(type) {
 	case *ast.FuncLit:
 		return x.Type, x.Body
 	case *ast.Ident:
 		obj := r.info.Uses[x]
-		if pkg := obj.Pkg(); pkg == nil || pkg.Name() != r.pkg.Name {
+		if !r.isLocal(obj.Pkg()) {
 			break
 		}
 		declId := r.revDefs[obj]
 		if fd, _ := r.parents[declId].(*ast.FuncDecl); fd != nil {
 			return fd.Type, fd.Body
 		}
-		fl := r.declIdentValue(declId).(*ast.FuncLit)
-		return fl.Type, fl.Body
+		if fl, _ := r.declIdentValue(declId).(*ast.FuncLit); fl != nil {
+			return fl.Type, fl.Body
+		}
 	}
 	return nil, nil
 }
@@ -456,13 +638,13 @@ func (r *reducer) declIdentValue(id *ast.Ident) ast.Expr {
 	switch y := r.parents[id].(type) {
 	case *ast.ValueSpec:
 		for i, name := range y.Names {
-			if name == id {
+			if name == id && i < len(y.Values) { // not var v T
 				return y.Values[i]
 			}
 		}
 	case *ast.AssignStmt:
 		for i, name := range y.Lhs {
-			if name == id {
+			if name == id && len(y.Lhs) == len(y.Rhs) { // not a, b := f()
 				return y.Rhs[i]
 			}
 		}
@@ -496,8 +678,8 @@ func (r *reducer) removeDecl(id *ast.Ident) (undo func()) {
 		oldAssgn := *x
 		for i, left := range x.Lhs {
 			if left == id {
-				x.Lhs = append(x.Lhs[:i], x.Lhs[i+1:]...)
-				x.Rhs = append(x.Rhs[:i], x.Rhs[i+1:]...)
+				x.Lhs = append(x.Lhs[:i:i], x.Lhs[i+1:]...)
+				x.Rhs = append(x.Rhs[:i:i], x.Rhs[i+1:]...)
 				break
 			}
 		}
@@ -534,7 +716,7 @@ func (r *reducer) removeSpec(spec ast.Spec) (undo func()) {
 	oldSpecs := gd.Specs
 	for i, sp := range oldSpecs {
 		if sp == spec {
-			gd.Specs = append(gd.Specs[:i], gd.Specs[i+1:]...)
+			gd.Specs = append(gd.Specs[:i:i], gd.Specs[i+1:]...)
 			break
 		}
 	}
@@ -546,18 +728,17 @@ func (r *reducer) removeSpec(spec ast.Spec) (undo func()) {
 		}
 	}
 	f := r.parents[gd].(*ast.File)
-	oldDecls := f.Decls
 	if len(gd.Specs) == 0 { // remove decl too
-		for i, decl := range oldDecls {
-			if decl == gd {
-				f.Decls = append(f.Decls[:i], f.Decls[i+1:]...)
-				break
-			}
+		gd.Specs = oldSpecs // to find its end
+		undo := r.removeDecls(f, gd)
+		gd.Specs = nil
+		return func() {
+			gd.Specs = oldSpecs
+			undo()
 		}
 	}
 	return func() {
 		gd.Specs = oldSpecs
-		f.Decls = oldDecls
 	}
 }
 
@@ -638,6 +819,11 @@ func setPos(node ast.Node, pos token.Pos) {
 		x.Star = pos
 	case *ast.IndexExpr:
 		setPos(x.X, pos)
+	case *ast.IndexListExpr:
+		setPos(x.X, pos)
+	case *ast.SelectorExpr:
+		setPos(x.X, pos)
+		x.Sel.NamePos = pos
 	case *ast.ExprStmt:
 		setPos(x.X, pos)
 	case *ast.CompositeLit:
@@ -669,6 +855,9 @@ func (r *reducer) adaptBlockNames(bl *ast.BlockStmt) (undo func()) {
 				break
 			}
 			scope := obj.Parent()
+			if scope == nil { // e.g. blank identifier
+				break
+			}
 			if scope.Parent().Lookup(x.Name) == nil {
 				break
 			}
@@ -683,6 +872,10 @@ func (r *reducer) adaptBlockNames(bl *ast.BlockStmt) (undo func()) {
 				})
 				use.Name = newName
 			}
+			undoIdents = append(undoIdents, undoIdent{
+				id:   x,
+				name: x.Name,
+			})
 			x.Name = newName
 		}
 		return true
@@ -749,6 +942,15 @@ func (r *reducer) afterDelete(nodes ...ast.Node) {
 			}
 		case *types.Var:
 			declIdent := r.revDefs[x]
+			switch y := r.parents[declIdent].(type) {
+			case *ast.ValueSpec:
+			case *ast.AssignStmt:
+				if len(y.Lhs) != len(y.Rhs) {
+					continue
+				}
+			default: // e.g. a func parameter
+				continue
+			}
 			vars = append(vars, redoVar{declIdent, declIdent.Name})
 			declIdent.Name = "_"
 			r.fixAssignTokParent(declIdent)
@@ -757,8 +959,8 @@ func (r *reducer) afterDelete(nodes ...ast.Node) {
 	}
 	if len(undos) > 0 {
 		r.deleteKeepUnderscore = func() {
-			for _, undo := range undos {
-				undo()
+			for i := len(undos) - 1; i >= 0; i-- {
+				undos[i]()
 			}
 		}
 	}
@@ -779,12 +981,17 @@ func (r *reducer) afterDelete(nodes ...ast.Node) {
 
 func (r *reducer) unusedAfterDelete(nodes ...ast.Node) (objs []types.Object) {
 	remaining := make(map[types.Object]int)
+	// declared within the deleted nodes, so already gone
+	deleted := make(map[types.Object]bool)
 	for _, node := range nodes {
 		if node == nil {
 			continue // for convenience
 		}
 		ast.Inspect(node, func(node ast.Node) bool {
 			id, _ := node.(*ast.Ident)
+			if obj := r.info.Defs[id]; id != nil && obj != nil {
+				deleted[obj] = true
+			}
 			obj := r.info.Uses[id]
 			if id == nil || obj == nil {
 				return true
@@ -804,6 +1011,12 @@ func (r *reducer) unusedAfterDelete(nodes ...ast.Node) (objs []types.Object) {
 			return true
 		})
 	}
+	for i := 0; i < len(objs); i++ {
+		if deleted[objs[i]] {
+			objs = append(objs[:i], objs[i+1:]...)
+			i--
+		}
+	}
 	return
 }
 
@@ -858,6 +1071,9 @@ func (r *reducer) parentStmts(stmt ast.Stmt) *[]ast.Stmt {
 
 func (r *reducer) replaceStmts(old ast.Stmt, with []ast.Stmt) (undo func()) {
 	stmts := r.parentStmts(old)
+	if stmts == nil {
+		return r.replaceStmtField(old, with)
+	}
 	orig := *stmts
 	i := 0
 	for ; i < len(orig); i++ {
@@ -870,12 +1086,85 @@ func (r *reducer) replaceStmts(old ast.Stmt, with []ast.Stmt) (undo func()) {
 	l = append(l, with...)
 	l = append(l, orig[i+1:]...)
 	*stmts = l
-	return func() { *stmts = orig }
+	return func() {
+		// other changes to the list may have been undone
+		// already, so undo just this one
+		*stmts = unreplaceStmts(*stmts, orig, i, with)
+	}
+}
+
+// replaceStmtField is like replaceStmts, for a statement that isn't in a
+// list, such as the init statement of a for loop. Many statements are
+// put in a block, and no statements leave the field empty.
+func (r *reducer) replaceStmtField(old ast.Stmt, with []ast.Stmt) (undo func()) {
+	ref := r.stmtRef(old)
+	if ref == nil {
+		return func() {}
+	}
+	switch len(with) {
+	case 0:
+		if _, ok := r.parents[old].(*ast.LabeledStmt); ok {
+			*ref = &ast.EmptyStmt{Semicolon: old.Pos(), Implicit: true}
+		} else {
+			*ref = nil
+		}
+	case 1:
+		*ref = with[0]
+	default:
+		*ref = &ast.BlockStmt{Lbrace: old.Pos(), List: with, Rbrace: old.End()}
+	}
+	return func() { *ref = old }
+}
+
+// unreplaceStmts undoes the replacement of orig[i] by with in list. If
+// the list changed since, the statement is put back next to the one it
+// followed or preceded in orig. If neither is left, list is returned as
+// is, as there is no telling where the statement should go.
+func unreplaceStmts(list, orig []ast.Stmt, i int, with []ast.Stmt) []ast.Stmt {
+	index := func(stmt ast.Stmt) int {
+		for j, stmt2 := range list {
+			if stmt2 == stmt {
+				return j
+			}
+		}
+		return -1
+	}
+	start, end := -1, -1
+	switch {
+	case len(with) > 0:
+		if start = index(with[0]); start >= 0 {
+			end = start + len(with)
+		}
+	case i == 0:
+		start = 0
+	case i == len(orig)-1:
+		start = len(list)
+	default:
+		if start = index(orig[i-1]); start >= 0 {
+			start++
+		} else if start = index(orig[i+1]); start < 0 {
+			start = -1
+		}
+	}
+	if end < 0 {
+		end = start
+	}
+	if start < 0 || end > len(list) {
+		return list
+	}
+	l := make([]ast.Stmt, 0, len(list)-(end-start)+1)
+	l = append(l, list[:start]...)
+	l = append(l, orig[i])
+	return append(l, list[end:]...)
 }
 
 func (r *reducer) replacedStmts(old ast.Stmt, with []ast.Stmt) bool {
 	undo := r.replaceStmts(old, with)
 	if r.okChange() {
+		if len(with) == 0 { // e.g. an empty block
+			r.mergeLines(old.Pos(), old.End()+1)
+			return true
+		}
 		r.mergeLines(old.Pos(), with[0].Pos())
 		r.mergeLines(with[len(with)-1].End(), old.End())
 		setPos(with[0], old.Pos())
@@ -888,36 +1177,6 @@ func (r *reducer) replacedStmts(old ast.Stmt, with []ast.Stmt) bool {
 	return false
 }
 
-func (r *reducer) reduceLit(l *ast.BasicLit) {
-	orig := l.Value
-	changeValue := func(val string) bool {
-		if l.Value == val {
-			return false
-		}
-		if l.Value = val; r.okChange() {
-			return true
-		}
-		l.Value = orig
-		return false
-	}
-	switch l.Kind {
-	case token.STRING:
-		if changeValue(`""`) {
-			if len(orig) > 10 {
-				orig = fmt.Sprintf(`%s..."`, orig[:7])
-			}
-			r.logChange(l, `%s -> ""`, orig)
-		}
-	case token.INT:
-		if changeValue(`0`) {
-			if len(orig) > 10 {
-				orig = fmt.Sprintf(`%s...`, orig[:7])
-			}
-			r.logChange(l, `%s -> 0`, orig)
-		}
-	}
-}
-
 func (r *reducer) reduceSlice(sl *ast.SliceExpr) {
 	r.afterDelete(sl.Low, sl.High, sl.Max)
 	if r.changedExpr(sl, sl.X) {
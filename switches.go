@@ -0,0 +1,161 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// reduceSwitch tries to simplify a switch statement, reporting whether
+// it changed anything.
+func (r *reducer) reduceSwitch(sw *ast.SwitchStmt) bool {
+	if len(sw.Body.List) == 1 {
+		cc := sw.Body.List[0].(*ast.CaseClause)
+		if sw.Init == nil {
+			if r.replacedStmts(sw, cc.Body) {
+				r.logChange(cc, "case inlined")
+				return true
+			}
+		} else {
+			deleted := []ast.Node{sw.Tag}
+			for _, expr := range cc.List {
+				deleted = append(deleted, expr)
+			}
+			if r.unwrapStmt(sw, sw.Body.Lbrace, []ast.Stmt{sw.Init}, &cc.Body, deleted) {
+				r.logChange(cc, "case inlined")
+				return true
+			}
+		}
+	}
+	if r.dropSwitchTag(sw) {
+		r.logChange(sw, "switch x { case a } -> switch { case x == a }")
+		return true
+	}
+	return false
+}
+
+// dropSwitchTag tries to remove the tag of a switch statement, comparing
+// it with the expressions of each case instead.
+func (r *reducer) dropSwitchTag(sw *ast.SwitchStmt) bool {
+	tag := sw.Tag
+	if tag == nil {
+		return false
+	}
+	var undos []func()
+	var added []ast.Expr
+	for _, stmt := range sw.Body.List {
+		cc := stmt.(*ast.CaseClause)
+		list := cc.List
+		cc.List = make([]ast.Expr, len(list))
+		for i, expr := range list {
+			if be, _ := expr.(*ast.BinaryExpr); be != nil {
+				expr = &ast.ParenExpr{Lparen: be.Pos(), X: be, Rparen: be.End()}
+			}
+			cc.List[i] = &ast.BinaryExpr{
+				X:     copyNode(tag, expr.Pos()).(ast.Expr),
+				OpPos: expr.Pos(),
+				Op:    token.EQL,
+				Y:     expr,
+			}
+			added = append(added, cc.List[i])
+		}
+		undos = append(undos, func() { cc.List = list })
+	}
+	sw.Tag = nil
+	r.afterDelete(tag)
+	if !r.okChange() {
+		sw.Tag = tag
+		for _, undo := range undos {
+			undo()
+		}
+		return false
+	}
+	for _, stmt := range sw.Body.List {
+		r.setParents(stmt, sw.Body)
+	}
+	return true
+}
+
+// removeCaseExpr tries to remove one of the expressions or types listed
+// by a case clause.
+func (r *reducer) removeCaseExpr(cc *ast.CaseClause) bool {
+	if len(cc.List) < 2 {
+		return false
+	}
+	list := cc.List
+	for i, expr := range list {
+		cc.List = append(list[:i:i], list[i+1:]...)
+		r.afterDelete(expr)
+		if r.okChange() {
+			r.logChange(expr, "removed case expr")
+			return true
+		}
+	}
+	cc.List = list
+	return false
+}
+
+// typeSwitchCase tries to replace a type switch by one of its cases. The
+// variable declared by the switch, if the case uses it, is declared by a
+// type assertion instead, as in v := x.(T).
+func (r *reducer) typeSwitchCase(ts *ast.TypeSwitchStmt) bool {
+	var assert *ast.TypeAssertExpr
+	var name *ast.Ident
+	switch x := ts.Assign.(type) {
+	case *ast.AssignStmt:
+		assert, _ = x.Rhs[0].(*ast.TypeAssertExpr)
+		name, _ = x.Lhs[0].(*ast.Ident)
+	case *ast.ExprStmt:
+		assert, _ = x.X.(*ast.TypeAssertExpr)
+	}
+	if assert == nil {
+		return false
+	}
+	for _, stmt := range ts.Body.List {
+		cc := stmt.(*ast.CaseClause)
+		var pre []ast.Stmt
+		if ts.Init != nil {
+			pre = append(pre, ts.Init)
+		}
+		var deleted []ast.Node
+		for _, stmt2 := range ts.Body.List {
+			if stmt2 != stmt {
+				deleted = append(deleted, stmt2)
+			}
+		}
+		var value ast.Expr
+		if obj := r.info.Implicits[cc]; name != nil && obj != nil && len(r.useIdents[obj]) > 0 {
+			pos := ts.Assign.Pos()
+			value = copyNode(assert.X, pos).(ast.Expr)
+			if len(cc.List) == 1 {
+				if id, _ := cc.List[0].(*ast.Ident); id == nil || id.Name != "nil" {
+					value = &ast.TypeAssertExpr{
+						X:      value,
+						Lparen: pos,
+						Type:   copyNode(cc.List[0], pos).(ast.Expr),
+						Rparen: pos,
+					}
+				}
+			}
+			pre = append(pre, &ast.AssignStmt{
+				Lhs:    []ast.Expr{&ast.Ident{NamePos: pos, Name: name.Name}},
+				TokPos: pos,
+				Tok:    token.DEFINE,
+				Rhs:    []ast.Expr{value},
+			})
+		} else {
+			deleted = append(deleted, ts.Assign)
+		}
+		if r.unwrapStmt(ts, ts.Body.Lbrace, pre, &cc.Body, deleted) {
+			if _, ok := value.(*ast.TypeAssertExpr); ok {
+				r.logChange(ts, "type switch -> x.(T)")
+			} else {
+				r.logChange(ts, "type switch -> case")
+			}
+			return true
+		}
+	}
+	return false
+}
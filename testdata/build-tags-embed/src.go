@@ -0,0 +1,11 @@
+package main
+
+import _ "embed"
+
+//go:embed data.go
+var data string
+
+func main() {
+	println("foo")
+	panic(data[:17])
+}
@@ -0,0 +1,8 @@
+//go:build crash
+
+package main
+
+func main() {
+	println("foo")
+	panic("crash")
+}
@@ -0,0 +1,5 @@
+//go:build !crash
+
+package main
+
+func main() {}
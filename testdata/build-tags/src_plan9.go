@@ -0,0 +1,5 @@
+package main
+
+func init() {
+	panic("plan9")
+}
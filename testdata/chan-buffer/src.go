@@ -0,0 +1,10 @@
+package main
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		<-ch
+	}()
+	ch <- 1
+	panic("foo")
+}
@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+func main() {
+	println("foo")
+	println("bar")
+	os.Exit(3)
+}
@@ -0,0 +1,14 @@
+package main
+
+import "reflect"
+
+type T struct {
+	m map[string][]byte
+	u uint64
+	p *T
+}
+
+func main() {
+	var t T
+	panic(reflect.TypeOf(t).Size())
+}
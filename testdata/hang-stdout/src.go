@@ -0,0 +1,10 @@
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("foo")
+	println("bar")
+	for {
+	}
+}
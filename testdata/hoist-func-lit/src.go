@@ -0,0 +1,12 @@
+package main
+
+func apply(f func([]int) int, s []int) int {
+	return f(s)
+}
+
+func main() {
+	last := func(s []int) int {
+		return s[len(s)]
+	}
+	println(apply(last, []int{1, 2}))
+}
@@ -3,7 +3,7 @@ package main
 func main() {
 	msg := 0
 	{
-		msg := 0.0
+		msg := 1.5
 		panic(msg)
 	}
 	panic(msg)
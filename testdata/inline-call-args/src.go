@@ -0,0 +1,31 @@
+package main
+
+func get(s []int, i int) int {
+	return s[i]
+}
+
+func index(s []int, i int) int {
+	if i < 0 {
+		return 0
+	}
+	return get(s, i+1)
+}
+
+func check(s []int, n int) {
+	var _ = index(s, n)
+}
+
+func find(s []int, n int) int {
+	for i := 0; i < n; i++ {
+		if s[i] == n {
+			return i
+		}
+	}
+	return -1
+}
+
+func main() {
+	s := []int{1, 2, 3}
+	println(find(s, 2))
+	check(s, 2)
+}
@@ -0,0 +1,14 @@
+package main
+
+func find(s []int, n int) int {
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			return s[i]
+		}
+	}
+	return -1
+}
+
+func main() {
+	find([]int{1}, 3)
+}
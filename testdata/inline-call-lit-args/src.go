@@ -0,0 +1,9 @@
+package main
+
+func main() {
+	s := "foobar"
+	func(s string, n int) {
+		println(len(s))
+		panic(s[n:])
+	}(s, 3)
+}
@@ -0,0 +1,10 @@
+package main
+
+func crash(s []int, verbose bool) {
+	println(s[3])
+}
+
+func main() {
+	var f func([]int, bool) = crash
+	crash([]int{1, 2}, f != nil)
+}
@@ -0,0 +1,13 @@
+package main
+
+func main() {
+	s := []int{1, 2}
+	n := func(k int) int {
+		m := k * 2
+		return m
+	}(len(s))
+	func() {
+		println(s[n])
+		return
+	}()
+}
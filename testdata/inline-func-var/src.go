@@ -0,0 +1,11 @@
+package main
+
+func get(s []int, i int) int {
+	return s[i]
+}
+
+func main() {
+	var getter func([]int, int) int = get
+	s := []int{1, 2}
+	println(getter(s, 3), getter(s, 0))
+}
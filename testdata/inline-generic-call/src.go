@@ -0,0 +1,9 @@
+package main
+
+func get[S ~[]E, E any](s S) E {
+	return s[3]
+}
+
+func main() {
+	get([]int{1})
+}
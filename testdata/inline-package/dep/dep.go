@@ -0,0 +1,11 @@
+package dep
+
+import "strings"
+
+func helper() string {
+	return "crash"
+}
+
+func Crash(n int) {
+	panic(strings.Repeat(helper(), n))
+}
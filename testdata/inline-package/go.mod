@@ -0,0 +1,3 @@
+module example.com/flatten
+
+go 1.16
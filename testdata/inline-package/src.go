@@ -0,0 +1,11 @@
+package main
+
+import "example.com/flatten/dep"
+
+func helper() int {
+	return 2
+}
+
+func main() {
+	dep.Crash(helper())
+}
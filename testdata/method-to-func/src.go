@@ -0,0 +1,34 @@
+package main
+
+type stack struct {
+	items []int
+}
+
+func (s *stack) push(v int) {
+	s.items = append(s.items, v)
+}
+
+func (s stack) peek(depth int) int {
+	return s.items[len(s.items)-1-depth]
+}
+
+type shower interface {
+	show() string
+}
+
+type named string
+
+func (n named) show() string { return string(n) }
+
+func apply(fn func(int), v int) {
+	fn(v)
+}
+
+func main() {
+	var s stack
+	apply(s.push, 1)
+	(*stack).push(&s, 2)
+	peek := stack.peek
+	var sh shower = named("x")
+	println(peek(s, len(sh.show())+1))
+}
@@ -0,0 +1,5 @@
+package dep
+
+func Crash() {
+	panic("crash")
+}
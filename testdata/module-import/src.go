@@ -0,0 +1,8 @@
+package main
+
+import "example.com/crasher/dep"
+
+func main() {
+	println("foo")
+	dep.Crash()
+}
@@ -0,0 +1,9 @@
+package main
+
+import "example.com/types/dep"
+
+var msg = dep.Msg
+
+func main() {
+	panic(msg)
+}
@@ -0,0 +1,8 @@
+package main
+
+func main() {
+	var m map[string]int
+	s := []int{1}
+	println(s[1])
+	m["a"] = 1
+}
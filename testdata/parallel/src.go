@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+func main() {
+	a := []int{1, 2, 3}
+	if true {
+		a = append(a, 4)
+	}
+	fmt.Println("foo" + "bar")
+	a[1] = -2
+	println(a[10])
+}
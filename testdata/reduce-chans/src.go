@@ -0,0 +1,24 @@
+package main
+
+import "sync"
+
+var mu sync.Mutex
+
+func main() {
+	total := 0
+	for i := 0; i < 2; i++ {
+		mu.Lock()
+		total += i
+		mu.Unlock()
+	}
+	ch := make(chan int, 1)
+	ch <- total
+	select {
+	case v := <-ch:
+		if v > 0 {
+			panic(v)
+		}
+	default:
+		println("empty")
+	}
+}
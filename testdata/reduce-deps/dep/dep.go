@@ -0,0 +1,6 @@
+package dep
+
+func Crash() {
+	println("foo")
+	panic("crash")
+}
@@ -0,0 +1,4 @@
+package dep
+
+// Unused does nothing.
+func Unused() {}
@@ -0,0 +1,5 @@
+package other
+
+func Print() {
+	println("bar")
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	. "example.com/deps/dep"
+	"example.com/deps/other"
+)
+
+func main() {
+	other.Print()
+	Crash()
+}
@@ -0,0 +1,14 @@
+package main
+
+func main() {
+	for i := 0; i < 3; i++ {
+		println("foo")
+	}
+	j := 0
+	for n := 5; ; j++ {
+		println(n)
+		if j > 1 {
+			panic("bar")
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+func main() {
+	s := []int{1, 2}
+	i, j := 0, 0
+	for i = 1; i < 10; j++ {
+		println(s[i])
+		i++
+	}
+	println(j)
+}
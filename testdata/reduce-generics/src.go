@@ -0,0 +1,24 @@
+package main
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+type Pair[K comparable, V any] struct {
+	key K
+	val V
+}
+
+func (p Pair[K, V]) first() K {
+	return p.key
+}
+
+func index[S ~[]E, E Number](s S, i int) E {
+	return s[i]
+}
+
+func main() {
+	p := Pair[string, float64]{key: "foo"}
+	_ = p.first()
+	index[[]int64, int64]([]int64{1, 2}, 3)
+}
@@ -0,0 +1,23 @@
+package main
+
+func main() {
+	xs := []int{3, 4, 5}
+	total := 0
+outer:
+	for i := 0; i < 3; i++ {
+		for _, x := range xs {
+			if x > 4 {
+				continue outer
+			}
+			total += x
+		}
+		if total > 100 {
+			break outer
+		}
+	}
+	for j := range xs {
+		if total > 0 {
+			panic(total + j)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+func items() map[string]int {
+	return map[string]int{"foo": 1}
+}
+
+func main() {
+	n := 0
+again:
+	for k := range items() {
+		n += len(k)
+	}
+	s := "done"
+	if n > 100 {
+		goto again
+	}
+	panic(s)
+}
@@ -0,0 +1,6 @@
+package crash
+
+func Index(i int) int {
+	s := []int{1}
+	return s[i]
+}
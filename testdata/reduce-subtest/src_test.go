@@ -0,0 +1,19 @@
+package crash
+
+import "testing"
+
+func TestOther(t *testing.T) {
+	println("other")
+}
+
+func TestCrash(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {
+		ExampleIndex()
+	})
+}
+
+func ExampleIndex() {
+	Index(3)
+}
+
+func BenchmarkIndex(b *testing.B) {}
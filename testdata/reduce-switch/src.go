@@ -0,0 +1,42 @@
+package main
+
+type shape interface{ area() int }
+
+type square struct{ side int }
+
+func (s square) area() int { return s.side * s.side }
+
+type circle struct{ r int }
+
+func (c circle) area() int { return 3 * c.r * c.r }
+
+func describe(v interface{}) int {
+	switch x := v.(type) {
+	case int, int64:
+		return 1
+	case square:
+		if x.side > 10 {
+			break
+		}
+		return x.area()
+	case circle:
+		return x.area()
+	default:
+		return 0
+	}
+	return -1
+}
+
+func main() {
+	n := describe(square{3})
+	switch c := n % 4; c {
+	case 0, 2:
+		println("even")
+	case 1, 3:
+		if c == 1 {
+			panic(n)
+		}
+	default:
+		println("other")
+	}
+}
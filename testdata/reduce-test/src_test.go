@@ -0,0 +1,17 @@
+package crash_test
+
+import (
+	"testing"
+
+	"example.com/crash"
+)
+
+func TestOther(t *testing.T) {
+	println("other")
+}
+
+func TestCrash(t *testing.T) {
+	crash.Index(3)
+}
+
+func BenchmarkFoo(b *testing.B) {}
@@ -0,0 +1,25 @@
+package main
+
+type celsius float64
+
+type reading = celsius
+
+type sensor struct {
+	name    string
+	history *[4]reading
+}
+
+func newSensor(name string, history *[4]reading) sensor {
+	return sensor{name: name, history: history}
+}
+
+func main() {
+	var hist [4]reading
+	s := newSensor("probe", &hist)
+	offset := len(s.name) + 1
+	idx := int(celsius(offset))
+	println(s.name, s.history[idx])
+
+	samples := [8]int{1, 2, 3, 4, 5, 6, 7, 8}
+	println(samples[idx])
+}
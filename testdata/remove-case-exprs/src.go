@@ -0,0 +1,16 @@
+package main
+
+func main() {
+	n := 0
+	for _, x := range []int{1, 3} {
+		switch x {
+		case 1, 2:
+			n++
+		case 3, 4:
+			n += 10
+		default:
+			n += 100
+		}
+	}
+	panic(n)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+const greeting = "hello"
+
+var count int
+
+// Point is a 2D point.
+type Point struct{ x, y int }
+
+func (p Point) String() string {
+	return fmt.Sprint(p.x, p.y)
+}
+
+func helper() int { return 3 }
+
+func main() {
+	fmt.Println(greeting)
+	count++
+	fmt.Println(count)
+	fmt.Println(Point{1, 2})
+	fmt.Println(helper())
+	count += helper()
+	fmt.Println("done")
+	fmt.Println(count)
+	panic("foo")
+}
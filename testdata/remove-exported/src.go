@@ -0,0 +1,30 @@
+package foo
+
+func init() {
+	println("init")
+}
+
+// T is exported, so it must stay.
+type T int
+
+type point struct{ x, y int }
+
+func (p point) sum() int { return p.x + p.y }
+
+func (p *point) reset() { p.x, p.y = 0, 0 }
+
+func helper() {}
+
+func recursive(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return recursive(n - 1)
+}
+
+func F() {
+	switch nil.(type) {
+	}
+}
+
+func G() { helper() }
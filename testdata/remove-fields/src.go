@@ -0,0 +1,41 @@
+package main
+
+import "strings"
+
+type shape interface {
+	area() int
+	name() string
+	fmtStringer
+}
+
+type fmtStringer interface {
+	String() string
+}
+
+type square struct {
+	side int
+}
+
+func (s square) area() int      { return s.side * s.side }
+func (s square) name() string   { return "square" }
+func (s square) String() string { return s.name() }
+
+type config struct {
+	name, path string
+	b          *strings.Builder
+	count      int
+	sq         square
+	inner
+}
+
+type inner struct{ debug bool }
+
+func main() {
+	c := config{name: "foo", path: "bar", count: 3}
+	c.count++
+	c.debug = true
+	var sh shape = c.sq
+	_ = sh.area()
+	var m map[string]int
+	m[c.name] = 1
+}
@@ -0,0 +1,18 @@
+package main
+
+type shape interface {
+	area() int
+	name() string
+}
+
+type square struct {
+	side int
+}
+
+func (s square) area() int    { panic("area") }
+func (s square) name() string { return "square" }
+
+func main() {
+	var sh shape = square{side: 2}
+	sh.area()
+}
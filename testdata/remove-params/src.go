@@ -0,0 +1,24 @@
+package main
+
+import "strings"
+
+type T struct{}
+
+func (T) crash(a, b int, sb *strings.Builder) (int, error) {
+	var s []int
+	return s[a], nil
+}
+
+func index(s []int, i int, unused ...string) (n int, ok bool) {
+	n = s[i]
+	return
+}
+
+func main() {
+	var t T
+	n, err := t.crash(0, 2, nil)
+	_ = err
+	println(n)
+	f := index
+	f([]int{1}, 0, "foo", "bar")
+}
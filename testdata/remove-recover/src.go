@@ -0,0 +1,15 @@
+package main
+
+func work(s []int) {
+	defer func() {
+		if r := recover(); r != nil {
+			println("cleaning up")
+			panic(r)
+		}
+	}()
+	println(s[3])
+}
+
+func main() {
+	work([]int{1})
+}
@@ -0,0 +1,25 @@
+package main
+
+type parseError struct {
+	lineNumber int
+}
+
+func lookupHeaderValue(headerLines []string, lineIndex int) string {
+	return headerLines[lineIndex]
+}
+
+func parseHTTPHeaderContinuationLine(requestHeaders []string) *parseError {
+	for headerIndex := 0; headerIndex < 3; headerIndex++ {
+		if lookupHeaderValue(requestHeaders, headerIndex) == "" {
+			return &parseError{lineNumber: headerIndex}
+		}
+	}
+	return nil
+}
+
+func main() {
+	var requestHeaders []string
+	if err := parseHTTPHeaderContinuationLine(requestHeaders); err != nil {
+		println(err.lineNumber)
+	}
+}
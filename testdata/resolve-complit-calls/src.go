@@ -0,0 +1,14 @@
+package main
+
+var n int
+
+func f() int {
+	n++
+	return n
+}
+
+func main() {
+	a := len([]int{f(), f()})
+	b := []int{f(), 2}[1]
+	panic(a + b + n)
+}
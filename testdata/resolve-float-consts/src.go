@@ -0,0 +1,5 @@
+package main
+
+func main() {
+	panic(3 / 2.0 * 1e2)
+}
@@ -0,0 +1,6 @@
+package main
+
+func main() {
+	println("unrelated")
+	panic([]int8{100}[0] * 2)
+}
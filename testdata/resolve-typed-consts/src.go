@@ -0,0 +1,9 @@
+package main
+
+type T int8
+
+const c T = 3
+
+func main() {
+	panic(c * 2)
+}
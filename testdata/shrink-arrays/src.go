@@ -0,0 +1,8 @@
+package main
+
+const n = 4
+
+func main() {
+	vals := [n * 2]int{9, 8, 7, 6, 5, 4, 3, 2}
+	panic(vals[0] + n)
+}
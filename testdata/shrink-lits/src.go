@@ -0,0 +1,9 @@
+package main
+
+import "fmt"
+
+var data = []byte{0x12, 0x34, 0x56, 0x78}
+
+func main() {
+	panic(fmt.Sprint("fuzzed input: CRASH!", data[1]))
+}
@@ -0,0 +1,10 @@
+package main
+
+func main() {
+	i := 0
+	for i < 2 {
+		i++
+		println("foo")
+	}
+	panic(i)
+}
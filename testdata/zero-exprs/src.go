@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+type config struct {
+	name  string
+	debug bool
+}
+
+func load(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &config{name: strings.TrimSpace(string(data))}, nil
+}
+
+func check(user string, cfg *config) {
+	if cfg.name != user {
+		panic("mismatch")
+	}
+}
+
+func main() {
+	cfg, err := load("/nonexistent/config")
+	if err != nil {
+		println(err.Error())
+	}
+	check(strings.ToLower(os.Getenv("USER")), cfg)
+}
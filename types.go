@@ -0,0 +1,317 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// isWithin reports whether node is within, or is, the node parent.
+func (r *reducer) isWithin(node, parent ast.Node) bool {
+	for ; node != nil; node = r.parents[node] {
+		if node == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineTypeSpec tries to replace the uses of a named type or an alias
+// by the type it's declared as, removing its declaration. Types with
+// methods are left alone, and so are struct and interface types used
+// more than once, as spelling them out everywhere would make the program
+// larger.
+func (r *reducer) inlineTypeSpec(ts *ast.TypeSpec) bool {
+	if ts.TypeParams != nil || !r.removableName(ts.Name) || len(r.typeMethods(ts)) > 0 {
+		return false
+	}
+	uses := r.useIdents[r.info.Defs[ts.Name]]
+	if len(uses) == 0 {
+		return false // left to removeTypeSpec
+	}
+	switch x := ts.Type.(type) {
+	case *ast.StructType:
+		if len(x.Fields.List) > 0 && len(uses) > 1 {
+			return false
+		}
+	case *ast.InterfaceType:
+		if len(x.Methods.List) > 0 && len(uses) > 1 {
+			return false
+		}
+	}
+	lp := r.filePkg(r.file)
+	var undos []func()
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	var added []ast.Expr
+	var nodes []ast.Node
+	for _, use := range uses {
+		if r.info.Defs[use] != nil { // struct{ T }
+			undoAll()
+			return false
+		}
+		if r.filePkg(r.nodeFile(use)) != lp || r.isWithin(use, ts) {
+			undoAll()
+			return false
+		}
+		ref := r.exprRef(use)
+		if ref == nil {
+			undoAll()
+			return false
+		}
+		typ := copyNode(ts.Type, use.Pos()).(ast.Expr)
+		if ce, _ := r.parents[use].(*ast.CallExpr); ce != nil && ce.Fun == use {
+			switch typ.(type) {
+			case *ast.StarExpr, *ast.FuncType, *ast.ChanType:
+				// (*T)(x) rather than *T(x)
+				typ = &ast.ParenExpr{Lparen: use.Pos(), X: typ, Rparen: use.End()}
+			}
+		}
+		*ref = typ
+		use := use
+		undos = append(undos, func() { *ref = use })
+		added = append(added, typ)
+		nodes = append(nodes, use)
+	}
+	gd := r.parents[ts].(*ast.GenDecl)
+	grouped := r.parents[gd] != r.file || len(gd.Specs) > 1
+	if grouped {
+		undos = append(undos, r.removeSpec(ts))
+	} else {
+		undos = append(undos, r.removeDecls(r.file, gd))
+	}
+	if !r.tryEdits(nodes, nil, undos) {
+		return false
+	}
+	if grouped {
+		r.mergeLines(ts.Pos(), ts.End()+1)
+	} else {
+		r.mergeDeclLines(r.file, gd)
+	}
+	for i, typ := range added {
+		r.setParents(typ, r.parents[nodes[i]])
+	}
+	if ts.Assign.IsValid() {
+		r.logChange(ts, "type alias inlined")
+	} else {
+		r.logChange(ts, "named type -> underlying type")
+	}
+	return true
+}
+
+// removeConversion tries to replace a conversion T(x) by x.
+func (r *reducer) removeConversion(ce *ast.CallExpr) bool {
+	if len(ce.Args) != 1 || ce.Ellipsis.IsValid() || !r.isType(ce.Fun) {
+		return false
+	}
+	r.afterDelete(ce.Fun)
+	if !r.changedExpr(ce, ce.Args[0]) {
+		return false
+	}
+	r.logChange(ce, "T(x) -> x")
+	return true
+}
+
+// removePointerType tries to replace the pointer type *T of vars, struct
+// fields or func params by T. Their uses as *p become p, and the values
+// &v given to them become v. Selectors like p.f are left as they are, as
+// they work on both, and so are p[i] and p[i:j] on pointers to arrays.
+func (r *reducer) removePointerType(star *ast.StarExpr) bool {
+	if !r.isType(star) {
+		return false
+	}
+	var names []*ast.Ident
+	var values []*ast.Expr
+	switch x := r.parents[star].(type) {
+	case *ast.ValueSpec:
+		names = x.Names
+		for i := range x.Values {
+			values = append(values, &x.Values[i])
+		}
+	case *ast.Field:
+		if len(x.Names) == 0 {
+			return false
+		}
+		names = x.Names
+		list := r.parents[x].(*ast.FieldList)
+		switch y := r.parents[list].(type) {
+		case *ast.StructType:
+		case *ast.FuncType:
+			fd, _ := r.parents[y].(*ast.FuncDecl)
+			if fd == nil || y.Params != list || !r.removableName(fd.Name) {
+				return false
+			}
+			calls, ok := r.funcCalls(r.info.Defs[fd.Name])
+			if !ok {
+				return false
+			}
+			nparams, first := 0, -1
+			for _, field := range list.List {
+				if field == x {
+					first = nparams
+				}
+				nparams += len(field.Names)
+			}
+			for _, ce := range calls {
+				if ce.Ellipsis.IsValid() || len(ce.Args) != nparams {
+					return false
+				}
+				for i := range x.Names {
+					values = append(values, &ce.Args[first+i])
+				}
+			}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	var undos []func()
+	undoAll := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+	var added []ast.Expr
+	var nodes []ast.Node
+	replace := func(ref *ast.Expr, with ast.Expr) {
+		old := *ref
+		*ref = with
+		undos = append(undos, func() { *ref = old })
+		added = append(added, with)
+		nodes = append(nodes, old)
+	}
+	// &v -> v
+	addrOf := func(ref *ast.Expr) bool {
+		ue, _ := ast.Unparen(*ref).(*ast.UnaryExpr)
+		if ue == nil || ue.Op != token.AND {
+			return false
+		}
+		replace(ref, ue.X)
+		return true
+	}
+	for _, ref := range values {
+		if !addrOf(ref) {
+			undoAll()
+			return false
+		}
+	}
+	for _, name := range names {
+		for _, use := range r.useIdents[r.info.Defs[name]] {
+			var expr ast.Expr = use
+			switch x := r.parents[use].(type) {
+			case *ast.SelectorExpr: // x.p
+				if x.Sel == use {
+					expr = x
+				}
+			case *ast.KeyValueExpr: // T{p: &v}
+				if x.Key == use {
+					if !addrOf(&x.Value) {
+						undoAll()
+						return false
+					}
+					continue
+				}
+			}
+			ok := false
+			switch x := r.parents[expr].(type) {
+			case *ast.StarExpr:
+				if ref := r.exprRef(x); ref != nil {
+					replace(ref, expr)
+					ok = true
+				}
+			case *ast.SelectorExpr:
+				ok = x.X == expr
+			case *ast.IndexExpr: // p[i] on a pointer to an array
+				ok = x.X == expr
+			case *ast.SliceExpr:
+				ok = x.X == expr
+			case *ast.AssignStmt:
+				if x.Tok != token.ASSIGN || len(x.Lhs) != len(x.Rhs) {
+					break
+				}
+				for i, lhs := range x.Lhs {
+					if lhs == expr {
+						ok = addrOf(&x.Rhs[i])
+					}
+				}
+			}
+			if !ok {
+				undoAll()
+				return false
+			}
+		}
+	}
+	replace(r.exprRef(star), star.X)
+	if !r.tryEdits(nodes, nil, undos) {
+		return false
+	}
+	for i, expr := range added {
+		r.parents[expr] = r.parents[nodes[i]]
+	}
+	r.logChange(star, "*T -> T")
+	return true
+}
+
+// shrinkArrayLen tries to make the length of an array type smaller,
+// dropping the elements of its composite literal that no longer fit.
+func (r *reducer) shrinkArrayLen(at *ast.ArrayType) bool {
+	if at.Len == nil {
+		return false
+	}
+	if _, ok := at.Len.(*ast.Ellipsis); ok {
+		return false
+	}
+	n, ok := r.resolveInt(at.Len)
+	if !ok || n < 1 {
+		return false
+	}
+	cl, _ := r.parents[at].(*ast.CompositeLit)
+	if cl != nil && cl.Type != at {
+		cl = nil
+	}
+	if cl != nil {
+		for _, elt := range cl.Elts {
+			if _, ok := elt.(*ast.KeyValueExpr); ok {
+				cl = nil // elements may be out of order
+				break
+			}
+		}
+	}
+	lens := []int{0}
+	if n > 1 {
+		lens = append(lens, 1)
+	}
+	if n/2 > 1 {
+		lens = append(lens, n/2)
+	}
+	for _, m := range lens {
+		orig, origElts := at.Len, []ast.Expr(nil)
+		deleted := []ast.Node{orig}
+		if cl != nil && len(cl.Elts) > m {
+			origElts = cl.Elts
+			for _, elt := range cl.Elts[m:] {
+				deleted = append(deleted, elt)
+			}
+			cl.Elts = cl.Elts[:m]
+		}
+		at.Len = &ast.BasicLit{ValuePos: orig.Pos(), Kind: token.INT, Value: strconv.Itoa(m)}
+		r.afterDelete(deleted...)
+		if r.okChange() {
+			r.parents[at.Len] = at
+			r.logChange(at, "[%d]T -> [%d]T", n, m)
+			return true
+		}
+		at.Len = orig
+		if origElts != nil {
+			cl.Elts = origElts
+		}
+	}
+	return false
+}
@@ -107,6 +107,10 @@ func (w *walker) walkSingle(v interface{}) {
 		w.walkOther(x.X)
 		w.walkOther(x.Index)
 
+	case *ast.IndexListExpr:
+		w.walkOther(x.X)
+		w.walkExprList(x.Indices)
+
 	case *ast.SliceExpr:
 		w.walkOther(x.X)
 		if x.Low != nil {
@@ -154,6 +158,9 @@ func (w *walker) walkSingle(v interface{}) {
 		w.walkOther(x.Fields)
 
 	case *ast.FuncType:
+		if x.TypeParams != nil {
+			w.walkOther(x.TypeParams)
+		}
 		if x.Params != nil {
 			w.walkOther(x.Params)
 		}
@@ -287,6 +294,9 @@ func (w *walker) walkSingle(v interface{}) {
 
 	case *ast.TypeSpec:
 		w.walkOther(x.Name)
+		if x.TypeParams != nil {
+			w.walkOther(x.TypeParams)
+		}
 		w.walkOther(x.Type)
 
 	case *ast.GenDecl:
@@ -0,0 +1,165 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+)
+
+// zeroValue returns an expression for the zero value of a type, such as
+// 0, "", nil or T{}. If typed is true, the expression has the type
+// itself rather than being untyped, as in T(0) or (*T)(nil). It returns
+// nil if there is none that can be written in the current file.
+func (r *reducer) zeroValue(t types.Type, typed bool, pos token.Pos) ast.Expr {
+	if _, ok := t.(*types.TypeParam); ok {
+		typ := r.typeExpr(t, pos)
+		if typ == nil {
+			return nil
+		}
+		return &ast.StarExpr{Star: pos, X: &ast.CallExpr{
+			Fun:    &ast.Ident{NamePos: pos, Name: "new"},
+			Lparen: pos,
+			Args:   []ast.Expr{typ},
+			Rparen: pos,
+		}}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		var val constant.Value
+		info := u.Info()
+		switch {
+		case info&types.IsBoolean != 0:
+			val = constant.MakeBool(false)
+		case info&types.IsString != 0:
+			val = constant.MakeString("")
+		case info&types.IsNumeric != 0:
+			val = constant.MakeInt64(0)
+		default:
+			return nil // e.g. unsafe.Pointer
+		}
+		return r.valueLit(val, t, typed, pos)
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan,
+		*types.Signature, *types.Interface:
+		if !r.universeName("nil", pos) {
+			return nil
+		}
+		var expr ast.Expr = &ast.Ident{NamePos: pos, Name: "nil"}
+		if !typed {
+			return expr
+		}
+		typ := r.typeExpr(t, pos)
+		if typ == nil {
+			return nil
+		}
+		switch typ.(type) {
+		case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr:
+		default: // e.g. (*T)(nil)
+			typ = &ast.ParenExpr{Lparen: pos, X: typ, Rparen: pos}
+		}
+		return &ast.CallExpr{Fun: typ, Lparen: pos, Args: []ast.Expr{expr}, Rparen: pos}
+	case *types.Struct, *types.Array:
+		typ := r.typeExpr(t, pos)
+		if typ == nil {
+			return nil
+		}
+		return &ast.CompositeLit{Type: typ, Lbrace: pos, Rbrace: pos}
+	}
+	return nil
+}
+
+// zeroableExpr reports whether an expression may be replaced by the zero
+// value of its type, given where it is. Expressions that are already
+// simple, such as names and literals, are left to other rules.
+func (r *reducer) zeroableExpr(expr ast.Expr) bool {
+	switch expr.(type) {
+	case *ast.Ident, *ast.BasicLit, *ast.CompositeLit, *ast.ParenExpr:
+		return false
+	}
+	tv, ok := r.info.Types[expr]
+	if !ok || !tv.IsValue() || tv.Value != nil {
+		return false
+	}
+	if _, ok := tv.Type.(*types.Tuple); ok {
+		return false
+	}
+	switch x := r.parents[expr].(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range x.Lhs {
+			if lhs == expr {
+				return false
+			}
+		}
+	case *ast.UnaryExpr:
+		return x.Op != token.AND // &x.f
+	case *ast.CallExpr:
+		return x.Fun != expr
+	case *ast.SelectorExpr, *ast.IndexExpr, *ast.SliceExpr, *ast.StarExpr,
+		*ast.TypeAssertExpr, *ast.IncDecStmt, *ast.RangeStmt,
+		*ast.ExprStmt, *ast.GoStmt, *ast.DeferStmt:
+		// e.g. f().x, or the value being used as a statement
+		return false
+	}
+	return true
+}
+
+// zeroExpr tries to replace an expression, such as a call or a
+// conversion, by the zero value of its type.
+func (r *reducer) zeroExpr(expr ast.Expr) bool {
+	if !r.zeroableExpr(expr) {
+		return false
+	}
+	typed := false
+	switch x := r.parents[expr].(type) {
+	case *ast.AssignStmt:
+		typed = x.Tok == token.DEFINE
+	case *ast.ValueSpec:
+		typed = x.Type == nil
+	}
+	zero := r.zeroValue(r.info.TypeOf(expr), typed, expr.Pos())
+	if zero == nil {
+		return false
+	}
+	r.afterDelete(expr)
+	if !r.changedExpr(expr, zero) {
+		return false
+	}
+	r.setParents(zero, r.parents[zero])
+	r.logChange(expr, "%s -> %s", shortLit(types.ExprString(expr)), types.ExprString(zero))
+	return true
+}
+
+// zeroAssign tries to replace the call on the right-hand side of an
+// assignment of multiple values, as in a, b := f(), by the zero values
+// of their types.
+func (r *reducer) zeroAssign(as *ast.AssignStmt) bool {
+	if len(as.Rhs) != 1 || len(as.Lhs) < 2 {
+		return false
+	}
+	tuple, _ := r.info.TypeOf(as.Rhs[0]).(*types.Tuple)
+	if tuple == nil || tuple.Len() != len(as.Lhs) {
+		return false
+	}
+	zeros := make([]ast.Expr, len(as.Lhs))
+	for i := range as.Lhs {
+		zeros[i] = r.zeroValue(tuple.At(i).Type(), as.Tok == token.DEFINE, as.Rhs[0].Pos())
+		if zeros[i] == nil {
+			return false
+		}
+	}
+	rhs := as.Rhs
+	as.Rhs = zeros
+	r.afterDelete(rhs[0])
+	if !r.okChange() {
+		as.Rhs = rhs
+		return false
+	}
+	for _, zero := range zeros {
+		r.setParents(zero, as)
+	}
+	r.logChange(as, "%s -> zero values", shortLit(types.ExprString(rhs[0])))
+	return true
+}